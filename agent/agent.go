@@ -0,0 +1,341 @@
+// Package agent implements an ssh-agent style signing agent. The agent
+// holds decrypted Ed25519 keys in memory for a limited time and signs
+// OSM-15 typed data for clients connecting over a Unix domain socket.
+// Only processes running as the same user may connect.
+//
+// Clients find the agent through the OSM15_AUTH_SOCK environment variable.
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/audit"
+)
+
+// EnvSocket is the environment variable holding the agent socket path.
+const EnvSocket = "OSM15_AUTH_SOCK"
+
+// Request operations.
+const (
+	opList   = "list"
+	opAdd    = "add"
+	opRemove = "remove"
+	opSign   = "sign"
+)
+
+// KeyInfo describes a key held by the agent. ExpiresAt is zero for keys
+// without a lifetime.
+type KeyInfo struct {
+	Address   string    `json:"address"`
+	PublicKey string    `json:"publicKey"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+type request struct {
+	Op      string           `json:"op"`
+	Address string           `json:"address,omitempty"`
+	Seed    string           `json:"seed,omitempty"`
+	TTL     time.Duration    `json:"ttl,omitempty"`
+	All     bool             `json:"all,omitempty"`
+	Data    *osm15.TypedData `json:"data,omitempty"`
+}
+
+type response struct {
+	Error     string    `json:"error,omitempty"`
+	Keys      []KeyInfo `json:"keys,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+type entry struct {
+	priv    ed25519.PrivateKey
+	expires time.Time
+}
+
+// Agent holds unlocked keys. Its zero value is not usable; use New.
+type Agent struct {
+	// DefaultTTL is the lifetime of keys added over the socket without
+	// one. Zero keeps them until they are removed.
+	DefaultTTL time.Duration
+	// AuditLog, when set, records every sign request.
+	AuditLog *audit.Log
+
+	mu   sync.Mutex
+	keys map[string]*entry
+	now  func() time.Time
+}
+
+// New returns an empty Agent.
+func New() *Agent {
+	return &Agent{keys: make(map[string]*entry), now: time.Now}
+}
+
+// Add stores the key for seed. A ttl of zero keeps the key until it is
+// removed or the agent exits. Adding a key again resets its lifetime.
+func (a *Agent) Add(seed []byte, ttl time.Duration) (KeyInfo, error) {
+	if len(seed) != ed25519.SeedSize {
+		return KeyInfo{}, fmt.Errorf("%w: seed must be %d bytes", osm15.ErrInvalidKey, ed25519.SeedSize)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	e := &entry{priv: priv}
+	if ttl > 0 {
+		e.expires = a.now().Add(ttl)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	addr := osm15.PublicKeyToAddress(priv.Public().(ed25519.PublicKey))
+	if old, ok := a.keys[addr]; ok {
+		wipe(old.priv)
+	}
+	a.keys[addr] = e
+	return keyInfo(addr, e), nil
+}
+
+// Remove drops the key for address and wipes it from memory.
+func (a *Agent) Remove(address string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.keys[address]
+	if !ok {
+		return fmt.Errorf("agent: no key for %s", address)
+	}
+	wipe(e.priv)
+	delete(a.keys, address)
+	return nil
+}
+
+// RemoveAll drops and wipes every key.
+func (a *Agent) RemoveAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for addr, e := range a.keys {
+		wipe(e.priv)
+		delete(a.keys, addr)
+	}
+}
+
+// List returns the keys that have not expired, sorted by address.
+func (a *Agent) List() []KeyInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expireLocked()
+	keys := make([]KeyInfo, 0, len(a.keys))
+	for addr, e := range a.keys {
+		keys = append(keys, keyInfo(addr, e))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Address < keys[j].Address })
+	return keys
+}
+
+// Sign validates and signs data with the key for address, or with the
+// only key held when address is empty.
+func (a *Agent) Sign(ctx context.Context, address string, data osm15.TypedData) (string, error) {
+	a.mu.Lock()
+	a.expireLocked()
+	var e *entry
+	if address == "" && len(a.keys) == 1 {
+		for _, only := range a.keys {
+			e = only
+		}
+	} else {
+		e = a.keys[address]
+	}
+	var priv ed25519.PrivateKey
+	if e != nil {
+		priv = append(ed25519.PrivateKey(nil), e.priv...)
+	}
+	a.mu.Unlock()
+
+	if priv == nil {
+		if address == "" {
+			return "", errors.New("agent: address is required unless exactly one key is loaded")
+		}
+		return "", fmt.Errorf("agent: no key for %s", address)
+	}
+	defer wipe(priv)
+
+	signer, err := osm15.NewCryptoSigner(priv)
+	if err != nil {
+		return "", err
+	}
+	return a.AuditLog.Sign(ctx, data, signer, nil, "agent")
+}
+
+// expireLocked drops keys whose lifetime has passed. a.mu must be held.
+func (a *Agent) expireLocked() {
+	now := a.now()
+	for addr, e := range a.keys {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			wipe(e.priv)
+			delete(a.keys, addr)
+		}
+	}
+}
+
+// Listen creates the socket at path, readable only by the current user,
+// and serves clients until ctx is cancelled. The directory holding the
+// socket is created with mode 0700 if needed; an existing one must be
+// owned by the current user with mode 0700. A stale socket left at path
+// is replaced, but any other file, or a socket another agent is still
+// serving, is an error. The socket is removed on return.
+func (a *Agent) Listen(ctx context.Context, path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := checkSocketDir(dir); err != nil {
+		return err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	ln, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	return a.Serve(ctx, ln)
+}
+
+// removeStaleSocket removes path if it is a socket nobody listens on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("agent: %s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("agent: another agent is listening on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// Serve accepts connections on ln until ctx is cancelled, rejecting peers
+// that run as another user.
+func (a *Agent) Serve(ctx context.Context, ln *net.UnixListener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.mu.Lock()
+				a.expireLocked()
+				a.mu.Unlock()
+			}
+		}
+	}()
+
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				a.RemoveAll()
+				return nil
+			}
+			return err
+		}
+		if err := checkPeer(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		go a.handle(ctx, conn)
+	}
+}
+
+func (a *Agent) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(a.dispatch(ctx, req)); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) dispatch(ctx context.Context, req request) response {
+	fail := func(err error) response { return response{Error: err.Error()} }
+
+	switch req.Op {
+	case opList:
+		return response{Keys: a.List()}
+	case opAdd:
+		seed, err := base64.StdEncoding.DecodeString(req.Seed)
+		if err != nil {
+			return fail(errors.New("agent: seed is not valid base64"))
+		}
+		defer wipe(seed)
+		ttl := req.TTL
+		if ttl == 0 {
+			ttl = a.DefaultTTL
+		}
+		info, err := a.Add(seed, ttl)
+		if err != nil {
+			return fail(err)
+		}
+		return response{Keys: []KeyInfo{info}}
+	case opRemove:
+		if req.All {
+			a.RemoveAll()
+			return response{}
+		}
+		if err := a.Remove(req.Address); err != nil {
+			return fail(err)
+		}
+		return response{}
+	case opSign:
+		if req.Data == nil {
+			return fail(errors.New("agent: sign request without data"))
+		}
+		sig, err := a.Sign(ctx, req.Address, *req.Data)
+		if err != nil {
+			return fail(err)
+		}
+		return response{Signature: sig}
+	}
+	return fail(fmt.Errorf("agent: unknown operation %q", req.Op))
+}
+
+func keyInfo(addr string, e *entry) KeyInfo {
+	return KeyInfo{
+		Address:   addr,
+		PublicKey: base64.StdEncoding.EncodeToString(e.priv.Public().(ed25519.PublicKey)),
+		ExpiresAt: e.expires,
+	}
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
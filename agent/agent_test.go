@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15"
+)
+
+func testData() osm15.TypedData {
+	return osm15.TypedData{
+		Domain:      osm15.TypedDomain{Name: "Agent", Version: "1", ChainID: 1},
+		Types:       map[string][]osm15.TypedMember{"Pay": {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}}},
+		PrimaryType: "Pay",
+		Message:     map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "amount": "1000"},
+	}
+}
+
+func TestAgent_SignOverSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Unix socket paths are short; keep the directory near the root.
+	dir, err := os.MkdirTemp("", "osm15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s", "agent.sock")
+
+	a := New()
+	done := make(chan error, 1)
+	go func() { done <- a.Listen(ctx, path) }()
+	var client *Client
+	for i := 0; i < 100; i++ {
+		if client, err = Dial(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer client.Close()
+
+	// 1. The socket is private to the user
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Socket mode = %v, %v", fi.Mode().Perm(), err)
+	}
+	if fi, _ := os.Stat(filepath.Dir(path)); fi.Mode().Perm() != 0700 {
+		t.Errorf("Socket directory mode = %v", fi.Mode().Perm())
+	}
+
+	// 2. Keys are added from a keystore and listed
+	privB64, pubB64, _ := osm15.GenerateKeypair()
+	ks, _ := osm15.EncryptKey(privB64, "pw")
+	if _, err := client.AddKeystore(ks, "wrong", 0); err == nil {
+		t.Error("Wrong password was accepted")
+	}
+	info, err := client.AddKeystore(ks, "pw", time.Hour)
+	if err != nil {
+		t.Fatalf("AddKeystore error: %v", err)
+	}
+	if info.PublicKey != pubB64 || info.ExpiresAt.IsZero() {
+		t.Errorf("Unexpected key info: %+v", info)
+	}
+	keys, err := client.List()
+	if err != nil || len(keys) != 1 || keys[0].Address != info.Address {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+
+	// 3. The agent signs typed data but not bare digests
+	signer, err := client.Signer("")
+	if err != nil {
+		t.Fatalf("Signer error: %v", err)
+	}
+	sig, err := osm15.SignTypedDataWith(ctx, testData(), signer)
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if valid, _ := osm15.VerifyTypedData(testData(), sig, pubB64); !valid {
+		t.Error("Agent signature does not verify")
+	}
+	if _, err := signer.SignDigest(ctx, make([]byte, 32)); !errors.Is(err, ErrDigestOnly) {
+		t.Errorf("SignDigest error = %v, want ErrDigestOnly", err)
+	}
+
+	// 4. Removed keys can no longer sign
+	if err := client.Remove(info.Address); err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	if _, err := client.Sign(info.Address, testData()); err == nil {
+		t.Error("Removed key still signs")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Listen error: %v", err)
+	}
+	if _, err := net.Dial("unix", path); err == nil {
+		t.Error("Socket still accepts connections after shutdown")
+	}
+}
+
+func TestAgent_ListenChecksPaths(t *testing.T) {
+	dir, err := os.MkdirTemp("", "osm15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a := New()
+
+	// 1. A directory others can enter is refused, not tightened
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0700)
+	os.Chmod(shared, 0755)
+	if err := a.Listen(ctx, filepath.Join(shared, "agent.sock")); err == nil {
+		t.Error("Listen accepted a 0755 directory")
+	}
+	if fi, _ := os.Stat(shared); fi.Mode().Perm() != 0755 {
+		t.Errorf("Directory mode changed to %v", fi.Mode().Perm())
+	}
+
+	// 2. Only sockets are replaced
+	file := filepath.Join(dir, "agent.sock")
+	os.WriteFile(file, []byte("keep"), 0600)
+	if err := a.Listen(ctx, file); err == nil {
+		t.Error("Listen replaced a regular file")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "keep" {
+		t.Error("Regular file was modified")
+	}
+
+	// 3. A stale socket is replaced; a live one is not
+	sock := filepath.Join(dir, "live.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Listen(ctx, sock); err == nil {
+		t.Error("Listen took over a live socket")
+	}
+	ln.SetUnlinkOnClose(false)
+	ln.Close()
+	if err := a.Listen(ctx, sock); err != nil {
+		t.Errorf("Listen on a stale socket: %v", err)
+	}
+}
+
+func TestAgent_Expiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := New()
+	a.now = func() time.Time { return now }
+
+	seed := make([]byte, 32)
+	info, err := a.Add(seed, time.Minute)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	if _, err := a.Add(seed[:31], 0); !errors.Is(err, osm15.ErrInvalidKey) {
+		t.Errorf("Short seed error = %v, want ErrInvalidKey", err)
+	}
+	if _, err := a.Sign(context.Background(), info.Address, testData()); err != nil {
+		t.Fatalf("Sign before expiry: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := a.Sign(context.Background(), info.Address, testData()); err == nil {
+		t.Error("Expired key still signs")
+	}
+	if keys := a.List(); len(keys) != 0 {
+		t.Errorf("Expired key still listed: %v", keys)
+	}
+}
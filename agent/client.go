@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15"
+)
+
+// ErrNoAgent is returned by DialEnv when OSM15_AUTH_SOCK is not set.
+var ErrNoAgent = errors.New("agent: " + EnvSocket + " is not set")
+
+// ErrDigestOnly is returned by an agent Signer's SignDigest: the agent
+// only signs typed data. Use osm15.SignTypedDataWith.
+var ErrDigestOnly = errors.New("agent: the agent does not sign bare digests")
+
+// Client talks to a running agent. It is safe for concurrent use; requests
+// are serialised over one connection.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+// Dial connects to the agent listening on the socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn)}, nil
+}
+
+// DialEnv connects to the agent named by OSM15_AUTH_SOCK.
+func DialEnv() (*Client, error) {
+	path := os.Getenv(EnvSocket)
+	if path == "" {
+		return nil, ErrNoAgent
+	}
+	return Dial(path)
+}
+
+// Close closes the connection to the agent.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// List returns the keys held by the agent.
+func (c *Client) List() ([]KeyInfo, error) {
+	resp, err := c.call(request{Op: opList})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+// Add hands a 32-byte Ed25519 seed to the agent for ttl, or until removed
+// when ttl is zero.
+func (c *Client) Add(seed []byte, ttl time.Duration) (KeyInfo, error) {
+	resp, err := c.call(request{Op: opAdd, Seed: base64.StdEncoding.EncodeToString(seed), TTL: ttl})
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if len(resp.Keys) != 1 {
+		return KeyInfo{}, errors.New("agent: malformed add response")
+	}
+	return resp.Keys[0], nil
+}
+
+// AddKeystore decrypts an encrypted keystore locally and hands the key to
+// the agent.
+func (c *Client) AddKeystore(keystoreJSON []byte, password string, ttl time.Duration) (KeyInfo, error) {
+	privateKeyB64, err := osm15.DecryptKey(keystoreJSON, password)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(privateKeyB64)
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("%w: keystore holds a malformed key", osm15.ErrInvalidKey)
+	}
+	defer wipe(seed)
+	return c.Add(seed, ttl)
+}
+
+// Remove asks the agent to forget the key for address.
+func (c *Client) Remove(address string) error {
+	_, err := c.call(request{Op: opRemove, Address: address})
+	return err
+}
+
+// RemoveAll asks the agent to forget every key.
+func (c *Client) RemoveAll() error {
+	_, err := c.call(request{Op: opRemove, All: true})
+	return err
+}
+
+// Sign asks the agent to sign data with the key for address, or with its
+// only key when address is empty, and returns the base64 signature.
+func (c *Client) Sign(address string, data osm15.TypedData) (string, error) {
+	resp, err := c.call(request{Op: opSign, Address: address, Data: &data})
+	if err != nil {
+		return "", err
+	}
+	return resp.Signature, nil
+}
+
+// Signer returns an osm15.Signer that signs through the agent with the key
+// for address, or with the agent's only key when address is empty.
+func (c *Client) Signer(address string) (osm15.Signer, error) {
+	keys, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	if address == "" && len(keys) != 1 {
+		if len(keys) == 0 {
+			return nil, errors.New("agent: the agent holds no keys")
+		}
+		return nil, errors.New("agent: the agent holds several keys; choose an address")
+	}
+	for _, k := range keys {
+		if address != "" && k.Address != address {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize || osm15.PublicKeyToAddress(pub) != k.Address {
+			return nil, fmt.Errorf("agent: agent returned a malformed key for %s", k.Address)
+		}
+		return &agentSigner{client: c, address: k.Address, pub: pub}, nil
+	}
+	return nil, fmt.Errorf("agent: no key for %s", address)
+}
+
+type agentSigner struct {
+	client  *Client
+	address string
+	pub     ed25519.PublicKey
+}
+
+func (s *agentSigner) Public() ed25519.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return nil, ErrDigestOnly
+}
+
+func (s *agentSigner) SignTypedData(ctx context.Context, data osm15.TypedData) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sig, err := s.client.Sign(s.address, data)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(sig)
+}
+
+func (c *Client) call(req request) (response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var resp response
+	if err := c.enc.Encode(req); err != nil {
+		return resp, err
+	}
+	if err := c.dec.Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
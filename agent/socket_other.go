@@ -0,0 +1,34 @@
+//go:build !unix
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// checkSocketDir can only check that dir is a directory on this platform;
+// file ownership and modes are not POSIX here.
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("agent: %s is not a directory", dir)
+	}
+	return nil
+}
+
+func listenUnix(path string) (*net.UnixListener, error) {
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkSocketDir refuses a socket directory that another user could enter
+// or replace: it must be a real directory owned by us with mode 0700.
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("agent: %s is not a directory", dir)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("agent: %s is owned by uid %d, not %d", dir, st.Uid, os.Getuid())
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("agent: %s has mode %04o, want 0700", dir, perm)
+	}
+	return nil
+}
+
+// listenUnix creates the socket with umask 0177, so it is never reachable
+// by other users, not even before a chmod.
+func listenUnix(path string) (*net.UnixListener, error) {
+	old := unix.Umask(0177)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	unix.Umask(old)
+	return ln, err
+}
@@ -0,0 +1,454 @@
+// Package audit keeps an append-only, hash-chained log of every signing
+// request handled by the CLI, the signing daemon and the agent.
+//
+// The log is a file of JSON lines. Each line holds a Record and the
+// SHA-256 of its exact bytes; every Record carries the hash of the one
+// before it, so editing, reordering or removing a line breaks the chain.
+// A head file next to the log (<log>.head) pins the sequence number, hash
+// and size of the last record so that truncating the end of the log is
+// detected too. Verify checks both.
+//
+// The hashes are unkeyed: they catch accidental damage and edits by anyone
+// who cannot also rewrite the head file, but someone with write access to
+// both files can rewrite the log and recompute every hash. To detect that,
+// keep head hashes somewhere the log's writer cannot change (a ticket,
+// another host) and check them later with a checkpoint.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/internal/filelock"
+)
+
+// Policy decisions recorded for each request.
+const (
+	// DecisionUnchecked means no policy was configured.
+	DecisionUnchecked = "unchecked"
+	// DecisionAllowed means the policy allowed the request.
+	DecisionAllowed = "allowed"
+	// DecisionRejected means the policy or validation rejected the
+	// request and nothing was signed.
+	DecisionRejected = "rejected"
+	// DecisionFailed means the request was not rejected, but signing it
+	// failed (invalid data without a policy, a signer or agent error) and
+	// nothing was signed.
+	DecisionFailed = "failed"
+)
+
+// ErrCorrupt is wrapped by every VerifyError.
+var ErrCorrupt = errors.New("audit log failed verification")
+
+// genesis is the Prev hash of the first record.
+var genesis = hex.EncodeToString(make([]byte, sha256.Size))
+
+// Record describes one signing request. Signature is empty when nothing
+// was signed; Reason then says why.
+type Record struct {
+	Seq         uint64            `json:"seq"`
+	Time        time.Time         `json:"time"`
+	Signer      string            `json:"signer"`
+	Domain      osm15.TypedDomain `json:"domain"`
+	PrimaryType string            `json:"primaryType"`
+	Digest      string            `json:"digest,omitempty"`
+	Source      string            `json:"source"`
+	Decision    string            `json:"decision"`
+	Reason      string            `json:"reason,omitempty"`
+	Signature   string            `json:"signature,omitempty"`
+	Prev        string            `json:"prev"`
+}
+
+// entry is one line of the log. Hash is the hex SHA-256 of Record's bytes
+// exactly as they appear in the file.
+type entry struct {
+	Hash   string          `json:"hash"`
+	Record json.RawMessage `json:"record"`
+}
+
+// Head is the checkpoint of the last record, stored in <log>.head.
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// VerifyError reports where a log stopped verifying. Line is 1-based; it
+// is zero for problems with the head file.
+type VerifyError struct {
+	Line   int
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Line == 0 {
+		return "audit: " + e.Reason
+	}
+	return fmt.Sprintf("audit: line %d: %s", e.Line, e.Reason)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// Log appends records to an audit log file. A nil *Log records nothing,
+// so callers can pass one around unconditionally. Several processes may
+// append to the same log; appends are serialised with a file lock.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	now  func() time.Time
+}
+
+// Open verifies the log at path, creating it if needed, and opens it for
+// appending. A log that fails verification is not opened, except that an
+// append interrupted before the head was updated is completed (see
+// Recover).
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := filelock.Lock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = recoverHead(path); err == nil {
+		_, err = Verify(path)
+	}
+	filelock.Unlock(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Log{path: path, f: f, now: time.Now}, nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// Append fills in r's Seq, Prev and, if zero, Time, writes it to the log
+// and advances the head. It returns the record as written.
+func (l *Log) Append(r Record) (Record, error) {
+	if l == nil {
+		return r, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := filelock.Lock(l.f); err != nil {
+		return r, err
+	}
+	defer filelock.Unlock(l.f)
+
+	// A previous append, here or in another process, may have written its
+	// record but not the head.
+	head, err := recoverHead(l.path)
+	if err != nil {
+		return r, err
+	}
+	info, err := l.f.Stat()
+	if err != nil {
+		return r, err
+	}
+	if info.Size() != head.Size {
+		return r, &VerifyError{Reason: fmt.Sprintf("log is %d bytes, head expects %d; refusing to append", info.Size(), head.Size)}
+	}
+
+	r.Seq = head.Seq + 1
+	r.Prev = head.Hash
+	if r.Time.IsZero() {
+		r.Time = l.now().UTC()
+	}
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	sum := sha256.Sum256(raw)
+	line, err := json.Marshal(entry{Hash: hex.EncodeToString(sum[:]), Record: raw})
+	if err != nil {
+		return r, err
+	}
+	line = append(line, '\n')
+
+	if _, err := l.f.Write(line); err != nil {
+		return r, err
+	}
+	if err := l.f.Sync(); err != nil {
+		return r, err
+	}
+	next := Head{Seq: r.Seq, Hash: hex.EncodeToString(sum[:]), Size: head.Size + int64(len(line))}
+	return r, writeHead(l.path, next)
+}
+
+// Sign checks data against policy (when non-nil), signs it with signer
+// and records the outcome. source names where the request came from, such
+// as the input file. If the record cannot be written the signature is
+// withheld and an error returned, so nothing is signed off the record.
+func (l *Log) Sign(ctx context.Context, data osm15.TypedData, signer osm15.Signer, policy *osm15.Policy, source string) (string, error) {
+	r := Record{
+		Signer:      osm15.PublicKeyToAddress(signer.Public()),
+		Domain:      data.Domain,
+		PrimaryType: data.PrimaryType,
+		Source:      source,
+		Decision:    DecisionUnchecked,
+	}
+	if digest, err := osm15.HashTypedData(data); err == nil {
+		r.Digest = hex.EncodeToString(digest)
+	}
+
+	var sig string
+	var err error
+	if policy != nil {
+		r.Decision = DecisionAllowed
+		err = policy.Check(data)
+	}
+	if err != nil {
+		r.Decision = DecisionRejected
+	} else if sig, err = osm15.SignTypedDataWith(ctx, data, signer); err != nil {
+		r.Decision = DecisionFailed
+	}
+	if err != nil {
+		r.Reason = err.Error()
+	}
+	r.Signature = sig
+
+	if _, logErr := l.Append(r); logErr != nil {
+		return "", fmt.Errorf("audit: signature withheld: %w", logErr)
+	}
+	return sig, err
+}
+
+// Verify checks the hash chain of the log at path against its head file
+// and returns the head. A missing log with no head is an empty log.
+func Verify(path string) (Head, error) {
+	head, err := readHead(path)
+	if err != nil {
+		return Head{}, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && head.Seq == 0 {
+		return head, nil
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	defer f.Close()
+
+	got, err := walk(f)
+	if err != nil {
+		return got, err
+	}
+	switch {
+	case got.Seq < head.Seq:
+		return got, &VerifyError{Reason: fmt.Sprintf("log truncated: ends at record %d, head is at %d", got.Seq, head.Seq)}
+	case got != head:
+		return got, &VerifyError{Reason: fmt.Sprintf("head file does not match the log (log ends at record %d)", got.Seq)}
+	}
+	return got, nil
+}
+
+// Recover completes an append that was interrupted between writing its
+// record and updating the head, e.g. by a crash or a full disk. If the log
+// holds exactly one complete record past the head, and everything up to
+// the head still verifies, the head is moved forward over it; a partial
+// last line past the head is cut off, as its append never returned. Any
+// other mismatch is left for Verify to report. Open and Append recover
+// automatically; Recover is for tools that only read the log.
+func Recover(path string) (Head, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return Head{}, err
+	}
+	defer f.Close()
+	if err := filelock.Lock(f); err != nil {
+		return Head{}, err
+	}
+	defer filelock.Unlock(f)
+	return recoverHead(path)
+}
+
+// recoverHead implements Recover with the log already locked, and returns
+// the head as it stands afterwards.
+func recoverHead(path string) (Head, error) {
+	head, err := readHead(path)
+	if ve, ok := err.(*VerifyError); ok && ve.Line == 0 && isMissing(headPath(path)) {
+		// The first append may have crashed before creating the head.
+		head, err = Head{Hash: genesis}, nil
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return head, nil
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	if int64(len(data)) <= head.Size {
+		return head, nil
+	}
+
+	// The records the head vouches for must be intact.
+	if got, err := walk(bytes.NewReader(data[:head.Size])); err != nil || got != head {
+		return head, nil
+	}
+	tail := data[head.Size:]
+	end := bytes.IndexByte(tail, '\n')
+	if end < 0 {
+		if err := os.Truncate(path, head.Size); err != nil {
+			return Head{}, err
+		}
+		return head, nil
+	}
+	if end != len(tail)-1 {
+		return head, nil
+	}
+	next, err := checkEntry(tail, head, int(head.Seq)+1)
+	if err != nil {
+		return head, nil
+	}
+	if err := writeHead(path, next); err != nil {
+		return Head{}, err
+	}
+	return next, nil
+}
+
+// walk follows the hash chain from the start of a log and returns the head
+// it ends at.
+func walk(r io.Reader) (Head, error) {
+	got := Head{Hash: genesis}
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(text) > 0 {
+				return got, &VerifyError{Line: line, Reason: "incomplete last line"}
+			}
+			return got, nil
+		}
+		if err != nil {
+			return got, err
+		}
+		if got, err = checkEntry(text, got, line); err != nil {
+			return got, err
+		}
+	}
+}
+
+// checkEntry verifies that text, a complete line, is the record after
+// prev, and returns the head that includes it.
+func checkEntry(text []byte, prev Head, line int) (Head, error) {
+	var e entry
+	if err := json.Unmarshal(text, &e); err != nil {
+		return prev, &VerifyError{Line: line, Reason: "malformed entry"}
+	}
+	sum := sha256.Sum256(e.Record)
+	if hex.EncodeToString(sum[:]) != e.Hash {
+		return prev, &VerifyError{Line: line, Reason: "record does not match its hash"}
+	}
+	var r Record
+	if err := json.Unmarshal(e.Record, &r); err != nil {
+		return prev, &VerifyError{Line: line, Reason: "malformed record"}
+	}
+	if r.Seq != prev.Seq+1 {
+		return prev, &VerifyError{Line: line, Reason: fmt.Sprintf("sequence %d follows %d", r.Seq, prev.Seq)}
+	}
+	if r.Prev != prev.Hash {
+		return prev, &VerifyError{Line: line, Reason: "previous-hash link is broken"}
+	}
+	return Head{Seq: r.Seq, Hash: e.Hash, Size: prev.Size + int64(len(text))}, nil
+}
+
+func isMissing(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
+// Records reads every record in the log at path without verifying it.
+func Records(path string) ([]Record, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e entry
+		var r Record
+		if err := json.Unmarshal(line, &e); err != nil {
+			return records, err
+		}
+		if err := json.Unmarshal(e.Record, &r); err != nil {
+			return records, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func headPath(path string) string {
+	return path + ".head"
+}
+
+// readHead loads the head file; a missing one is the empty log's head.
+func readHead(path string) (Head, error) {
+	data, err := ioutil.ReadFile(headPath(path))
+	if os.IsNotExist(err) {
+		if info, statErr := os.Stat(path); statErr == nil && info.Size() > 0 {
+			return Head{}, &VerifyError{Reason: "head file is missing for a non-empty log"}
+		}
+		return Head{Hash: genesis}, nil
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	var head Head
+	if err := json.Unmarshal(data, &head); err != nil {
+		return Head{}, &VerifyError{Reason: "malformed head file"}
+	}
+	return head, nil
+}
+
+// writeHead replaces the head file atomically.
+func writeHead(path string, head Head) error {
+	data, _ := json.Marshal(head)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(headPath(path))+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), headPath(path))
+}
@@ -0,0 +1,233 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dayuwidayadi57/osm15"
+)
+
+func testData(amount string) osm15.TypedData {
+	return osm15.TypedData{
+		Domain:      osm15.TypedDomain{Name: "Audit", Version: "1", ChainID: 1},
+		Types:       map[string][]osm15.TypedMember{"Pay": {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}}},
+		PrimaryType: "Pay",
+		Message:     map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "amount": amount},
+	}
+}
+
+func TestAudit_ChainAndTampering(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	privB64, _, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(privB64)
+	policy, err := osm15.ParsePolicy([]byte(`{"domains": [{"name": "Audit"}], "types": {"Pay": {"fields": {"amount": {"max": "100"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+
+	// 1. Signatures and rejections are both recorded
+	if _, err := log.Sign(ctx, testData("5"), signer, policy, "a.json"); err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if _, err := log.Sign(ctx, testData("500"), signer, policy, "b.json"); !errors.Is(err, osm15.ErrPolicyViolation) {
+		t.Fatalf("Policy rejection error = %v", err)
+	}
+	if _, err := log.Sign(ctx, testData("7"), signer, nil, "c.json"); err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	log.Close()
+
+	head, err := Verify(path)
+	if err != nil || head.Seq != 3 {
+		t.Fatalf("Verify = %+v, %v", head, err)
+	}
+	records, err := Records(path)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Records = %d, %v", len(records), err)
+	}
+	want := []string{DecisionAllowed, DecisionRejected, DecisionUnchecked}
+	for i, r := range records {
+		if r.Decision != want[i] || r.Signer != osm15.PublicKeyToAddress(signer.Public()) || r.PrimaryType != "Pay" || r.Digest == "" {
+			t.Errorf("Record %d = %+v", i, r)
+		}
+	}
+	if records[1].Signature != "" || records[1].Reason == "" || records[0].Signature == "" {
+		t.Error("Rejected record carries a signature or lacks a reason")
+	}
+
+	// 2. Reopening continues the chain
+	log, err = Open(path)
+	if err != nil {
+		t.Fatalf("Reopen error: %v", err)
+	}
+	log.Sign(ctx, testData("9"), signer, nil, "d.json")
+	log.Close()
+	if head, err := Verify(path); err != nil || head.Seq != 4 {
+		t.Fatalf("Verify after reopen = %+v, %v", head, err)
+	}
+
+	// 3. Any change to the file is detected
+	original, _ := ioutil.ReadFile(path)
+	lines := bytes.SplitAfter(original, []byte("\n"))
+	tampered := map[string][]byte{
+		"edited":    bytes.Replace(original, []byte(`"source":"a.json"`), []byte(`"source":"x.json"`), 1),
+		"dropped":   bytes.Join(append(lines[:1:1], lines[2:]...), nil),
+		"swapped":   bytes.Join([][]byte{lines[1], lines[0], lines[2], lines[3]}, nil),
+		"truncated": bytes.Join(lines[:3], nil),
+		"partial":   original[:len(original)-10],
+	}
+	for name, data := range tampered {
+		ioutil.WriteFile(path, data, 0600)
+		if _, err := Verify(path); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s log: Verify error = %v, want ErrCorrupt", name, err)
+		}
+		if _, err := Open(path); err == nil {
+			t.Errorf("%s log: Open succeeded", name)
+		}
+	}
+
+	// 4. Removing the head of a non-empty log is detected
+	ioutil.WriteFile(path, original, 0600)
+	os.Remove(path + ".head")
+	if _, err := Verify(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Missing head: Verify error = %v", err)
+	}
+}
+
+// failingSigner refuses every request, like an agent whose key expired.
+type failingSigner struct{ osm15.Signer }
+
+func (failingSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return nil, errors.New("agent: key expired")
+}
+
+func TestAudit_FailedSigning(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	privB64, _, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(privB64)
+	policy, _ := osm15.ParsePolicy([]byte(`{"domains": [{"name": "Audit"}], "types": {"Pay": {}}}`))
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+
+	// Signer errors and invalid data are recorded as failed, without a
+	// signature, whether or not a policy allowed the request first
+	if _, err := log.Sign(ctx, testData("5"), failingSigner{signer}, policy, "a.json"); err == nil {
+		t.Error("Sign with a failing signer succeeded")
+	}
+	if _, err := log.Sign(ctx, testData("5"), failingSigner{signer}, nil, "b.json"); err == nil {
+		t.Error("Sign with a failing signer succeeded")
+	}
+	if _, err := log.Sign(ctx, testData("-1"), signer, nil, "c.json"); err == nil {
+		t.Error("Sign of a negative uint256 succeeded")
+	}
+	log.Close()
+
+	records, err := Records(path)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Records = %d, %v", len(records), err)
+	}
+	for i, r := range records {
+		if r.Decision != DecisionFailed || r.Signature != "" || r.Reason == "" {
+			t.Errorf("Record %d = %+v", i, r)
+		}
+	}
+}
+
+func TestAudit_NilLog(t *testing.T) {
+	privB64, pubB64, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(privB64)
+
+	var log *Log
+	sig, err := log.Sign(context.Background(), testData("1"), signer, nil, "")
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if valid, _ := osm15.VerifyTypedData(testData("1"), sig, pubB64); !valid {
+		t.Error("Signature from a nil log does not verify")
+	}
+	if err := log.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAudit_InterruptedAppend(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	privB64, _, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(privB64)
+
+	log, _ := Open(path)
+	log.Sign(ctx, testData("1"), signer, nil, "a.json")
+	log.Sign(ctx, testData("2"), signer, nil, "b.json")
+	log.Close()
+	twoHead, _ := ioutil.ReadFile(path + ".head")
+	twoLog, _ := ioutil.ReadFile(path)
+
+	log, _ = Open(path)
+	log.Sign(ctx, testData("3"), signer, nil, "c.json")
+	log.Close()
+	threeLog, _ := ioutil.ReadFile(path)
+
+	// 1. Crash after the record was synced but before the head was written
+	ioutil.WriteFile(path+".head", twoHead, 0600)
+	if _, err := Verify(path); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Verify before recovery error = %v", err)
+	}
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open after interrupted append error: %v", err)
+	}
+	log.Sign(ctx, testData("4"), signer, nil, "d.json")
+	log.Close()
+	if head, err := Verify(path); err != nil || head.Seq != 4 {
+		t.Fatalf("Verify after recovery = %+v, %v", head, err)
+	}
+
+	// 2. Crash in the middle of writing the record
+	ioutil.WriteFile(path, threeLog[:len(twoLog)+20], 0600)
+	ioutil.WriteFile(path+".head", twoHead, 0600)
+	if head, err := Recover(path); err != nil || head.Seq != 2 {
+		t.Fatalf("Recover partial line = %+v, %v", head, err)
+	}
+	if head, err := Verify(path); err != nil || head.Seq != 2 {
+		t.Fatalf("Verify after cutting the partial line = %+v, %v", head, err)
+	}
+
+	// 3. Crash during the very first append, before any head existed
+	first := filepath.Join(t.TempDir(), "first.log")
+	log, _ = Open(first)
+	log.Sign(ctx, testData("1"), signer, nil, "a.json")
+	log.Close()
+	os.Remove(first + ".head")
+	if log, err := Open(first); err != nil {
+		t.Errorf("Open after interrupted first append error: %v", err)
+	} else {
+		log.Close()
+	}
+
+	// 4. Anything more than one record past the head is still corruption
+	firstLine := threeLog[:bytes.IndexByte(threeLog, '\n')+1]
+	var e entry
+	json.Unmarshal(firstLine, &e)
+	oneHead, _ := json.Marshal(Head{Seq: 1, Hash: e.Hash, Size: int64(len(firstLine))})
+	ioutil.WriteFile(path, threeLog, 0600)
+	ioutil.WriteFile(path+".head", oneHead, 0600)
+	if _, err := Open(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open two records past the head error = %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package osm15
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"runtime"
+	"sync"
+
+	"filippo.io/edwards25519"
+)
+
+// BatchItem is one signature to check. ID is copied to the result so
+// callers can tell items apart, e.g. by file name.
+type BatchItem struct {
+	ID        string
+	Data      TypedData
+	Signature string
+	PublicKey string
+}
+
+// BatchResult is the outcome for one BatchItem. Error is set when the item
+// could not be checked at all (bad schema, malformed key); an item with a
+// well-formed but wrong signature is simply not Valid.
+type BatchResult struct {
+	ID      string `json:"id"`
+	Valid   bool   `json:"valid"`
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchReport aggregates the results of VerifyBatch, in input order.
+type BatchReport struct {
+	Total   int           `json:"total"`
+	Valid   int           `json:"valid"`
+	Invalid int           `json:"invalid"`
+	Errors  int           `json:"errors"`
+	Results []BatchResult `json:"results"`
+}
+
+// BatchOptions tunes VerifyBatch.
+type BatchOptions struct {
+	// Workers is the number of goroutines; zero means GOMAXPROCS.
+	Workers int
+	// Registry caches compiled schemas across calls; nil uses a fresh one,
+	// which still compiles each distinct schema only once per batch.
+	Registry *Registry
+	// Cofactored checks signatures with the cofactored Ed25519 equation
+	// (as in ZIP-215), which can be batched. Signatures built from
+	// small-order points may then be valid here but not for
+	// VerifyTypedData, which like ed25519.Verify is cofactorless. Honest
+	// signers never produce such signatures.
+	Cofactored bool
+}
+
+// VerifyBatch checks many signatures in parallel. Items sharing a schema
+// compile it once. Each item gets the same result as VerifyTypedData.
+//
+// With Cofactored set, each worker takes a run of up to maxBatchVerify
+// items and checks their signatures with one Ed25519 batch equation; when
+// the equation fails, the run is verified item by item, with the same
+// equation, to find the bad ones. Either way an item's result does not
+// depend on the other items.
+func VerifyBatch(ctx context.Context, items []BatchItem, opts BatchOptions) (*BatchReport, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	registry := opts.Registry
+	if registry == nil {
+		registry = NewRegistry()
+	}
+	size := (len(items) + workers - 1) / workers
+	if size > maxBatchVerify {
+		size = maxBatchVerify
+	}
+
+	results := make([]BatchResult, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range jobs {
+				end := min(start+size, len(items))
+				verifyBatchRun(registry, items[start:end], results[start:end], opts.Cofactored)
+			}
+		}()
+	}
+
+	var err error
+dispatch:
+	for start := 0; start < len(items); start += size {
+		select {
+		case jobs <- start:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BatchReport{Total: len(items), Results: results}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			report.Errors++
+		case r.Valid:
+			report.Valid++
+		default:
+			report.Invalid++
+		}
+	}
+	return report, nil
+}
+
+// maxBatchVerify caps the number of signatures in one batch equation, so a
+// single bad signature costs at most this many individual verifications.
+const maxBatchVerify = 64
+
+// verifyBatchRun fills results for a run of items.
+func verifyBatchRun(registry *Registry, items []BatchItem, results []BatchResult, cofactored bool) {
+	var batch []batchSignature
+	var pending []int
+	for i, item := range items {
+		results[i] = BatchResult{ID: item.ID}
+		pub, err := base64.StdEncoding.DecodeString(item.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			results[i].Error = "public key must be a base64 Ed25519 key"
+			continue
+		}
+		results[i].Address = PublicKeyToAddress(pub)
+		digest, err := registry.HashTypedData(item.Data)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(item.Signature)
+		if err != nil {
+			continue
+		}
+		if !cofactored {
+			results[i].Valid = ed25519.Verify(pub, digest, sig)
+			continue
+		}
+		bs, ok := parseBatchSignature(pub, digest, sig)
+		if !ok {
+			continue
+		}
+		batch = append(batch, bs)
+		pending = append(pending, i)
+	}
+
+	if len(batch) > 1 && verifyEd25519Batch(batch) {
+		for _, i := range pending {
+			results[i].Valid = true
+		}
+		return
+	}
+	for j, i := range pending {
+		results[i].Valid = verifyCofactored(batch[j])
+	}
+}
+
+// batchSignature is a signature whose encoding ed25519.Verify would
+// accept, decoded for the cofactored equation.
+type batchSignature struct {
+	a, r *edwards25519.Point
+	s, k *edwards25519.Scalar
+}
+
+// parseBatchSignature decodes pub and sig, rejecting everything
+// ed25519.Verify rejects before its group equation: a malformed key, a
+// non-canonical S and an R that does not round-trip.
+func parseBatchSignature(pub, msg, sig []byte) (batchSignature, bool) {
+	if len(sig) != ed25519.SignatureSize || sig[63]&224 != 0 {
+		return batchSignature{}, false
+	}
+	a, err := new(edwards25519.Point).SetBytes(pub)
+	if err != nil {
+		return batchSignature{}, false
+	}
+	r, err := new(edwards25519.Point).SetBytes(sig[:32])
+	if err != nil || !bytes.Equal(r.Bytes(), sig[:32]) {
+		return batchSignature{}, false
+	}
+	s, err := new(edwards25519.Scalar).SetCanonicalBytes(sig[32:])
+	if err != nil {
+		return batchSignature{}, false
+	}
+	h := sha512.New()
+	h.Write(sig[:32])
+	h.Write(pub)
+	h.Write(msg)
+	k, _ := new(edwards25519.Scalar).SetUniformBytes(h.Sum(nil))
+	return batchSignature{a: a, r: r, s: s, k: k}, true
+}
+
+// verifyCofactored checks one signature with the cofactored equation
+// [8](S*B - R - k*A) = 0, which verifyEd25519Batch also uses.
+func verifyCofactored(bs batchSignature) bool {
+	minusK := edwards25519.NewScalar().Negate(bs.k)
+	check := new(edwards25519.Point).VarTimeDoubleScalarBaseMult(minusK, bs.a, bs.s)
+	check.Subtract(check, bs.r)
+	return check.MultByCofactor(check).Equal(edwards25519.NewIdentityPoint()) == 1
+}
+
+// verifyEd25519Batch checks all signatures at once: with random 128-bit
+// z_i it tests [8](-(sum z_i*s_i)B + sum z_i*R_i + sum (z_i*k_i)A_i) = 0
+// in a single multi-scalar multiplication.
+func verifyEd25519Batch(batch []batchSignature) bool {
+	scalars := make([]*edwards25519.Scalar, 0, 2*len(batch)+1)
+	points := make([]*edwards25519.Point, 0, 2*len(batch)+1)
+	sumS := edwards25519.NewScalar()
+	var buf [64]byte
+	for _, bs := range batch {
+		if _, err := rand.Read(buf[:16]); err != nil {
+			return false
+		}
+		z, _ := edwards25519.NewScalar().SetUniformBytes(buf[:])
+		sumS.MultiplyAdd(z, bs.s, sumS)
+		scalars = append(scalars, z, edwards25519.NewScalar().Multiply(z, bs.k))
+		points = append(points, bs.r, bs.a)
+	}
+	scalars = append(scalars, sumS.Negate(sumS))
+	points = append(points, edwards25519.NewGeneratorPoint())
+
+	check := new(edwards25519.Point).VarTimeMultiScalarMult(scalars, points)
+	return check.MultByCofactor(check).Equal(edwards25519.NewIdentityPoint()) == 1
+}
@@ -0,0 +1,88 @@
+package osm15
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// parseFixedBytesType reports N for a "bytesN" type name, 1 <= N <= 32.
+func parseFixedBytesType(typeName string) (int, bool) {
+	if !strings.HasPrefix(typeName, "bytes") || len(typeName) == len("bytes") {
+		return 0, false
+	}
+	digits := typeName[len("bytes"):]
+	if digits[0] == '0' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 || n > 32 {
+		return 0, false
+	}
+	return n, true
+}
+
+// encodeBool encodes a Go bool as a 32-byte word holding 0 or 1.
+func encodeBool(path, typeName string, value interface{}) ([]byte, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return nil, mismatch(path, typeName, value)
+	}
+	word := make([]byte, 32)
+	if b {
+		word[31] = 1
+	}
+	return word, nil
+}
+
+// encodeFixedBytes encodes exactly n bytes right-padded to a 32-byte word.
+func encodeFixedBytes(path, typeName string, n int, value interface{}) ([]byte, error) {
+	raw, err := toBytes(value)
+	if err != nil {
+		return nil, &EncodeError{Path: path, Type: typeName, Err: err}
+	}
+	if len(raw) != n {
+		return nil, &EncodeError{
+			Path: path,
+			Type: typeName,
+			Err:  fmt.Errorf("%w: expected %d bytes, got %d", ErrOutOfRange, n, len(raw)),
+		}
+	}
+	word := make([]byte, 32)
+	copy(word, raw)
+	return word, nil
+}
+
+// toBytes converts the accepted byte representations: []byte, byte arrays,
+// 0x-prefixed hex strings and standard padded base64 strings. Any string
+// starting with "0x" is treated as hex.
+func toBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+			raw, err := hex.DecodeString(v[2:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid hex %q", ErrTypeMismatch, v)
+			}
+			return raw, nil
+		}
+		raw, err := base64.StdEncoding.Strict().DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid base64 %q", ErrTypeMismatch, v)
+		}
+		return raw, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+		raw := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(raw), rv)
+		return raw, nil
+	}
+	return nil, fmt.Errorf("%w: got %T", ErrTypeMismatch, value)
+}
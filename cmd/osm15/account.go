@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/wallet"
+)
+
+const accountUsage = "Usage: account list|new|import|export|default [-dir <keystore dir>] ..."
+
+// runAccount implements `osm15 account` and its subcommands.
+func runAccount(args []string) {
+	if len(args) == 0 {
+		fmt.Println(accountUsage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		accountList(args[1:])
+	case "new":
+		accountNew(args[1:])
+	case "import":
+		accountImport(args[1:])
+	case "export":
+		accountExport(args[1:])
+	case "default":
+		accountDefault(args[1:])
+	default:
+		fmt.Println(accountUsage)
+		os.Exit(1)
+	}
+}
+
+func accountList(args []string) {
+	listCmd := flag.NewFlagSet("account list", flag.ExitOnError)
+	dir := dirFlag(listCmd)
+	listCmd.Parse(args)
+
+	accounts, err := openWallet(*dir).List()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if len(accounts) == 0 {
+		fmt.Printf("No accounts in %s. Create one with `osm15 account new`.\n", *dir)
+		return
+	}
+	for _, a := range accounts {
+		mark := " "
+		if a.Default {
+			mark = "*"
+		}
+		fmt.Printf("%s %s  %s\n", mark, a.Address, a.Label)
+	}
+}
+
+func accountNew(args []string) {
+	newCmd := flag.NewFlagSet("account new", flag.ExitOnError)
+	dir := dirFlag(newCmd)
+	label := newCmd.String("label", "", "Account label")
+	pass := newCmd.String("pass", "", "Password (prompted if empty)")
+	kdf := newCmd.String("kdf", osm15.KDFArgon2id, "Key derivation function: argon2id or scrypt")
+	profile := newCmd.String("profile", "interactive", "Cost profile: interactive, moderate or sensitive")
+	newCmd.Parse(args)
+
+	opts, err := keystoreOptions(*kdf, *profile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	w := openWallet(*dir)
+	if *pass == "" {
+		*pass = readPassword("Password for the new account: ")
+		if readPassword("Repeat password: ") != *pass {
+			fmt.Println("Error: passwords do not match")
+			os.Exit(1)
+		}
+	}
+	a, err := w.New(*pass, *label, opts)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Created %s (%s)\n", a.Address, a.Path)
+}
+
+func accountImport(args []string) {
+	importCmd := flag.NewFlagSet("account import", flag.ExitOnError)
+	dir := dirFlag(importCmd)
+	file := importCmd.String("file", "", "Keystore file to import")
+	label := importCmd.String("label", "", "Account label")
+	pass := importCmd.String("pass", "", "Keystore password (prompted if empty)")
+	importCmd.Parse(args)
+
+	if *file == "" {
+		fmt.Println("Usage: account import -file <ks.json> [-label <label>] [-pass <pw>] [-dir <dir>]")
+		os.Exit(1)
+	}
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	w := openWallet(*dir)
+	if *pass == "" {
+		*pass = readPassword("Password for " + *file + ": ")
+	}
+	a, err := w.Import(data, *pass, *label)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %s (%s)\n", a.Address, a.Path)
+}
+
+func accountExport(args []string) {
+	exportCmd := flag.NewFlagSet("account export", flag.ExitOnError)
+	dir := dirFlag(exportCmd)
+	name := exportCmd.String("account", "", "Address or label (default: the default account)")
+	out := exportCmd.String("out", "", "Output file (default: stdout)")
+	exportCmd.Parse(args)
+
+	w := openWallet(*dir)
+	if *name == "" {
+		a, err := w.Default()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		*name = a.Address
+	}
+	data, err := w.Export(*name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		fmt.Println(string(data))
+		return
+	}
+	if err := ioutil.WriteFile(*out, data, 0600); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func accountDefault(args []string) {
+	defaultCmd := flag.NewFlagSet("account default", flag.ExitOnError)
+	dir := dirFlag(defaultCmd)
+	defaultCmd.Parse(args)
+
+	w := openWallet(*dir)
+	if defaultCmd.NArg() > 0 {
+		if err := w.SetDefault(defaultCmd.Arg(0)); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	a, err := w.Default()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s  %s\n", a.Address, a.Label)
+}
+
+func dirFlag(fs *flag.FlagSet) *string {
+	dir, err := wallet.DefaultDir()
+	if err != nil {
+		dir = ""
+	}
+	return fs.String("dir", dir, "Keystore directory (default $"+wallet.EnvDir+" or ~/.osm15/keystore)")
+}
+
+func openWallet(dir string) *wallet.Wallet {
+	if dir == "" {
+		fmt.Println("Error: no keystore directory; pass -dir")
+		os.Exit(1)
+	}
+	w, err := wallet.Open(dir)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return w
+}
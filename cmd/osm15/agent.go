@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15/agent"
+)
+
+// runAgent implements `osm15 agent` and its add, remove and list
+// subcommands.
+func runAgent(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "add":
+			agentAdd(args[1:])
+			return
+		case "remove":
+			agentRemove(args[1:])
+			return
+		case "list":
+			agentList(args[1:])
+			return
+		}
+	}
+
+	agentCmd := flag.NewFlagSet("agent", flag.ExitOnError)
+	socket := agentCmd.String("socket", defaultAgentSocket(), "Socket path")
+	ttl := agentCmd.Duration("ttl", 0, "Default key lifetime, e.g. 30m (0 keeps keys until removed)")
+	auditFile := agentCmd.String("audit", os.Getenv("OSM15_AUDIT_LOG"), "Audit log to record signatures in (default $OSM15_AUDIT_LOG)")
+	agentCmd.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := agent.New()
+	a.DefaultTTL = *ttl
+	a.AuditLog = openAuditLog(*auditFile)
+	defer a.AuditLog.Close()
+	fmt.Printf("%s=%s; export %s;\n", agent.EnvSocket, *socket, agent.EnvSocket)
+	if err := a.Listen(ctx, *socket); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func agentAdd(args []string) {
+	addCmd := flag.NewFlagSet("agent add", flag.ExitOnError)
+	walletFile := addCmd.String("wallet", "", "Keystore file")
+	password := addCmd.String("pass", "", "Keystore password (prompted when empty)")
+	ttl := addCmd.Duration("ttl", 0, "Key lifetime, e.g. 30m (default: the agent's -ttl)")
+	addCmd.Parse(args)
+
+	if *walletFile == "" {
+		fmt.Println("Usage: agent add -wallet <ks.json> [-pass <pw>] [-ttl <duration>]")
+		os.Exit(1)
+	}
+	ksData, err := ioutil.ReadFile(*walletFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *password == "" {
+		*password = readPassword("Password for " + *walletFile + ": ")
+	}
+
+	client := dialAgent()
+	defer client.Close()
+	info, err := client.AddKeystore(ksData, *password, *ttl)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Added %s%s\n", info.Address, expiry(info))
+}
+
+func agentRemove(args []string) {
+	removeCmd := flag.NewFlagSet("agent remove", flag.ExitOnError)
+	address := removeCmd.String("address", "", "Address of the key to remove")
+	all := removeCmd.Bool("all", false, "Remove every key")
+	removeCmd.Parse(args)
+
+	if *address == "" && !*all {
+		fmt.Println("Usage: agent remove -address <addr> | -all")
+		os.Exit(1)
+	}
+
+	client := dialAgent()
+	defer client.Close()
+	var err error
+	if *all {
+		err = client.RemoveAll()
+	} else {
+		err = client.Remove(*address)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Removed")
+}
+
+func agentList(args []string) {
+	listCmd := flag.NewFlagSet("agent list", flag.ExitOnError)
+	listCmd.Parse(args)
+
+	client := dialAgent()
+	defer client.Close()
+	keys, err := client.List()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if len(keys) == 0 {
+		fmt.Println("The agent has no keys.")
+		return
+	}
+	for _, k := range keys {
+		fmt.Printf("%s%s\n", k.Address, expiry(k))
+	}
+}
+
+func dialAgent() *agent.Client {
+	client, err := agent.DialEnv()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return client
+}
+
+func expiry(k agent.KeyInfo) string {
+	if k.ExpiresAt.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" (expires in %s)", time.Until(k.ExpiresAt).Round(time.Second))
+}
+
+// defaultAgentSocket places the socket in a per-user directory.
+func defaultAgentSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "osm15", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("osm15-%d", os.Getuid()), "agent.sock")
+}
@@ -1,105 +1,258 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/ed25519"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/agent"
+	"github.com/dayuwidayadi57/osm15/audit"
+	"github.com/dayuwidayadi57/osm15/hsm"
+	"github.com/dayuwidayadi57/osm15/internal/atomicfile"
+	"github.com/dayuwidayadi57/osm15/remote"
+	"github.com/dayuwidayadi57/osm15/wallet"
 
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/term"
 )
 
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("Usage: osm15 <command> [<args>]")
-		fmt.Println("Commands: generate, sign, batch-sign, watch-sign, encrypt, decrypt")
+		fmt.Println("Commands: generate, sign, batch-sign, watch-sign, encrypt, decrypt, explain, vectors, serve, agent, audit, cosign, verify-batch, migrate, passwd, account, restore")
 		os.Exit(1)
 	}
 
 	switch os.Args[1] {
 	case "generate":
-		pub, priv, _ := ed25519.GenerateKey(nil)
-		fmt.Printf("Private Key (Base64): %s\n", base64.StdEncoding.EncodeToString(priv.Seed()))
-		fmt.Printf("Public Key (Base64):  %s\n", base64.StdEncoding.EncodeToString(pub))
-		fmt.Printf("Address:              %s\n", osm15.PublicKeyToAddress(pub))
+		genCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+		useMnemonic := genCmd.Bool("mnemonic", false, "Generate a BIP-39 mnemonic instead of a raw seed")
+		words := genCmd.Int("words", 24, "Mnemonic length: 12, 15, 18, 21 or 24 words")
+		passphrase := genCmd.String("passphrase", "", "Optional BIP-39 passphrase")
+		genCmd.Parse(os.Args[2:])
+
+		if !*useMnemonic {
+			pub, priv, _ := ed25519.GenerateKey(nil)
+			fmt.Printf("Private Key (Base64): %s\n", base64.StdEncoding.EncodeToString(priv.Seed()))
+			fmt.Printf("Public Key (Base64):  %s\n", base64.StdEncoding.EncodeToString(pub))
+			fmt.Printf("Address:              %s\n", osm15.PublicKeyToAddress(pub))
+			return
+		}
+		mnemonic, err := osm15.GenerateMnemonic(*words)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		signer := mnemonicSigner(mnemonic, *passphrase)
+		fmt.Printf("Mnemonic:             %s\n", mnemonic)
+		fmt.Printf("Public Key (Base64):  %s\n", base64.StdEncoding.EncodeToString(signer.Public()))
+		fmt.Printf("Address:              %s\n", osm15.PublicKeyToAddress(signer.Public()))
+		fmt.Println("Write the mnemonic down; `osm15 restore` turns it back into a keystore.")
+
+	case "restore":
+		restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+		out := restoreCmd.String("out", "", "Keystore file to write")
+		label := restoreCmd.String("label", "", "Import into the keystore directory under this label instead of -out")
+		dir := dirFlag(restoreCmd)
+		passphrase := restoreCmd.String("passphrase", "", "BIP-39 passphrase used when the mnemonic was generated")
+		pass := restoreCmd.String("pass", "", "Password for the new keystore (prompted if empty)")
+		kdf := restoreCmd.String("kdf", osm15.KDFArgon2id, "Key derivation function: argon2id or scrypt")
+		profile := restoreCmd.String("profile", "interactive", "Cost profile: interactive, moderate or sensitive")
+		restoreCmd.Parse(os.Args[2:])
+
+		if *out == "" && *label == "" {
+			fmt.Println("Usage: restore -out <wallet.json> | -label <label> [-passphrase <p>] [-pass <pw>]")
+			os.Exit(1)
+		}
+		opts, err := keystoreOptions(*kdf, *profile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		mnemonic := readMnemonic()
+		if err := osm15.ValidateMnemonic(mnemonic); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		privB64, err := osm15.KeyFromMnemonic(mnemonic, *passphrase)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if *pass == "" {
+			*pass = readPassword("Password for the keystore: ")
+			if readPassword("Repeat password: ") != *pass {
+				fmt.Println("Error: passwords do not match")
+				os.Exit(1)
+			}
+		}
+		ks, err := osm15.EncryptKeyWithOptions(privB64, *pass, opts)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		if *label != "" {
+			a, err := openWallet(*dir).Import(ks, *pass, *label)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Restored %s (%s)\n", a.Address, a.Path)
+			return
+		}
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if _, err := f.Write(ks); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if err := f.Close(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		signer := mnemonicSigner(mnemonic, *passphrase)
+		fmt.Printf("Restored %s to %s\n", osm15.PublicKeyToAddress(signer.Public()), *out)
 
 	case "sign":
 		signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
 		signFile := signCmd.String("file", "", "TypedData JSON file")
 		walletFile := signCmd.String("wallet", "", "Keystore file path")
 		password := signCmd.String("pass", "", "Keystore password")
+		pkcs11Module := signCmd.String("pkcs11-module", "", "PKCS#11 module path (sign with an HSM key instead of a keystore)")
+		tokenLabel := signCmd.String("token-label", "", "PKCS#11 token label")
+		keyLabel := signCmd.String("key-label", "", "PKCS#11 key label")
+		keyID := signCmd.String("key-id", "", "PKCS#11 key ID (hex)")
+		pin := signCmd.String("pin", os.Getenv("OSM15_PKCS11_PIN"), "PKCS#11 user PIN (default $OSM15_PKCS11_PIN)")
+		address := signCmd.String("address", "", "Agent key to sign with (when no -wallet is given)")
+		auditFile := signCmd.String("audit", os.Getenv("OSM15_AUDIT_LOG"), "Audit log to record the signature in (default $OSM15_AUDIT_LOG)")
 		signCmd.Parse(os.Args[2:])
 
-		if *signFile == "" || *walletFile == "" || *password == "" {
-			fmt.Println("Usage: sign -file <data.json> -wallet <wallet.json> -pass <password>")
+		useHSM := *pkcs11Module != ""
+		useAgent := !useHSM && *walletFile == "" && os.Getenv(agent.EnvSocket) != ""
+		if *signFile == "" || (!useHSM && !useAgent && *walletFile == "") || (useHSM && *keyLabel == "" && *keyID == "") {
+			fmt.Println("Usage: sign -file <data.json> -wallet <wallet.json> [-pass <password>]")
+			fmt.Println("       sign -file <data.json> -pkcs11-module <lib.so> [-token-label <label>] -key-label <label> [-pin <pin>]")
+			fmt.Println("       sign -file <data.json> [-address <addr>]    (with $" + agent.EnvSocket + " set)")
 			os.Exit(1)
 		}
 
-		ksData, _ := ioutil.ReadFile(*walletFile)
-		privKeyB64, err := osm15.DecryptKey(ksData, *password)
-		if err != nil {
-			fmt.Println("Error: Invalid password")
-			os.Exit(1)
+		var signer osm15.Signer
+		if useHSM {
+			id, err := hex.DecodeString(*keyID)
+			if err != nil {
+				fmt.Println("Error: -key-id must be hex")
+				os.Exit(1)
+			}
+			hsmSigner, err := hsm.Open(hsm.Config{
+				Module:     *pkcs11Module,
+				TokenLabel: *tokenLabel,
+				PIN:        *pin,
+				KeyLabel:   *keyLabel,
+				KeyID:      id,
+			})
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			defer hsmSigner.Close()
+			signer = hsmSigner
+		} else {
+			signer = openSigner(*walletFile, *password, *address)
 		}
 
+		auditLog := openAuditLog(*auditFile)
+		defer auditLog.Close()
+
 		fileData, _ := ioutil.ReadFile(*signFile)
 		var typedData osm15.TypedData
 		json.Unmarshal(fileData, &typedData)
 
-		sig, _ := osm15.SignTypedData(typedData, privKeyB64)
-		output, _ := osm15.ExportToJSON(typedData, sig)
+		sig, err := auditLog.Sign(context.Background(), typedData, signer, nil, *signFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		output, _ := osm15.ExportSignedPayload(typedData, sig, signer.Public())
 		fmt.Println(string(output))
 
 	case "batch-sign":
 		batchCmd := flag.NewFlagSet("batch-sign", flag.ExitOnError)
 		inDir := batchCmd.String("in", "", "Input directory")
 		outDir := batchCmd.String("out", "", "Output directory")
-		walletFile := batchCmd.String("wallet", "", "Keystore file")
+		walletFile := batchCmd.String("wallet", "", "Keystore file (default: the agent at $OSM15_AUTH_SOCK)")
 		password := batchCmd.String("pass", "", "Password")
+		address := batchCmd.String("address", "", "Agent key to sign with")
+		policyFile := batchCmd.String("policy", "", "Signing policy file; files it rejects are moved to -rejected")
+		rejectDir := batchCmd.String("rejected", "rejected", "Directory for rejected files")
+		auditFile := batchCmd.String("audit", os.Getenv("OSM15_AUDIT_LOG"), "Audit log to record signatures in (default $OSM15_AUDIT_LOG)")
 		batchCmd.Parse(os.Args[2:])
 
-		ksData, _ := ioutil.ReadFile(*walletFile)
-		privKey, err := osm15.DecryptKey(ksData, *password)
-		if err != nil {
-			fmt.Println("Error: Invalid password")
-			os.Exit(1)
-		}
+		policy := loadPolicy(*policyFile)
+		auditLog := openAuditLog(*auditFile)
+		defer auditLog.Close()
+		signer := openSigner(*walletFile, *password, *address)
 
 		files, _ := ioutil.ReadDir(*inDir)
 		os.MkdirAll(*outDir, 0755)
 
+		failed := 0
 		for _, f := range files {
 			if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
-				processFile(*inDir+"/"+f.Name(), *outDir, privKey)
+				path := filepath.Join(*inDir, f.Name())
+				if err := processFile(path, *outDir, *rejectDir, signer, policy, auditLog); err != nil {
+					fmt.Printf("Error: %s: %v\n", path, err)
+					failed++
+				}
 			}
 		}
+		if failed > 0 {
+			fmt.Printf("Batch signing completed; %d file(s) failed and were left in place.\n", failed)
+			os.Exit(1)
+		}
 		fmt.Println("Batch signing completed!")
 
 	case "watch-sign":
 		watchCmd := flag.NewFlagSet("watch-sign", flag.ExitOnError)
 		inDir := watchCmd.String("in", "pending_tx", "Input directory")
 		outDir := watchCmd.String("out", "signed_tx", "Output directory")
-		walletFile := watchCmd.String("wallet", "", "Keystore file")
+		walletFile := watchCmd.String("wallet", "", "Keystore file (default: the agent at $OSM15_AUTH_SOCK)")
 		password := watchCmd.String("pass", "", "Password")
+		address := watchCmd.String("address", "", "Agent key to sign with")
+		policyFile := watchCmd.String("policy", "", "Signing policy file; files it rejects are moved to -rejected")
+		rejectDir := watchCmd.String("rejected", "rejected", "Directory for rejected files")
+		auditFile := watchCmd.String("audit", os.Getenv("OSM15_AUDIT_LOG"), "Audit log to record signatures in (default $OSM15_AUDIT_LOG)")
 		watchCmd.Parse(os.Args[2:])
 
-		if *walletFile == "" || *password == "" {
-			fmt.Println("Usage: watch-sign -in <dir> -out <dir> -wallet <ks.json> -pass <pw>")
+		if *walletFile == "" && os.Getenv(agent.EnvSocket) == "" {
+			fmt.Println("Usage: watch-sign -in <dir> -out <dir> -wallet <ks.json> [-pass <pw>]")
+			fmt.Println("       watch-sign -in <dir> -out <dir> [-address <addr>]    (with $" + agent.EnvSocket + " set)")
 			os.Exit(1)
 		}
 
-		ksData, _ := ioutil.ReadFile(*walletFile)
-		privKey, err := osm15.DecryptKey(ksData, *password)
-		if err != nil {
-			fmt.Println("Error: Invalid password")
-			os.Exit(1)
+		policy := loadPolicy(*policyFile)
+		if policy == nil {
+			fmt.Println("Warning: no -policy given; every well-formed file will be signed.")
 		}
+		auditLog := openAuditLog(*auditFile)
+		defer auditLog.Close()
+		signer := openSigner(*walletFile, *password, *address)
 
 		os.MkdirAll(*inDir, 0755)
 		os.MkdirAll(*outDir, 0755)
@@ -107,33 +260,62 @@ func main() {
 		watcher, _ := fsnotify.NewWatcher()
 		defer watcher.Close()
 
-		go func() {
-			for {
-				select {
-				case event, ok := <-watcher.Events:
-					if !ok { return }
-					if event.Op&fsnotify.Create == fsnotify.Create && strings.HasSuffix(event.Name, ".json") {
-						fmt.Printf("Detected: %s\n", filepath.Base(event.Name))
-						processFile(event.Name, *outDir, privKey)
+		watcher.Add(*inDir)
+		fmt.Printf("Watcher active on ./%s. Press Ctrl+C to stop.\n", *inDir)
+
+		// A file is signed once it has had no writes for watchSettle, so
+		// one still being written is not read half-way; moving finished
+		// files into -in avoids the wait. Failures other than rejections
+		// are retried.
+		due := make(map[string]time.Time)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok { return }
+				if event.Op&(fsnotify.Create|fsnotify.Write) != 0 && strings.HasSuffix(event.Name, ".json") {
+					due[event.Name] = time.Now().Add(watchSettle)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok { return }
+				fmt.Println("Watcher error:", err)
+			case now := <-ticker.C:
+				for name, at := range due {
+					if now.Before(at) {
+						continue
+					}
+					delete(due, name)
+					if _, err := os.Stat(name); err != nil {
+						continue
+					}
+					fmt.Printf("Detected: %s\n", filepath.Base(name))
+					if err := processFile(name, *outDir, *rejectDir, signer, policy, auditLog); err != nil {
+						fmt.Printf("Error: %s: %v (retrying in %s)\n", name, err, watchRetry)
+						due[name] = now.Add(watchRetry)
 					}
-				case err, ok := <-watcher.Errors:
-					if !ok { return }
-					fmt.Println("Watcher error:", err)
 				}
 			}
-		}()
-
-		watcher.Add(*inDir)
-		fmt.Printf("Watcher active on ./%s. Press Ctrl+C to stop.\n", *inDir)
-		select {}
+		}
 
 	case "encrypt":
 		encCmd := flag.NewFlagSet("encrypt", flag.ExitOnError)
 		key := encCmd.String("key", "", "Private key (Base64)")
 		pass := encCmd.String("pass", "", "Password")
+		kdf := encCmd.String("kdf", osm15.KDFArgon2id, "Key derivation function: argon2id or scrypt")
+		profile := encCmd.String("profile", "interactive", "Cost profile: interactive, moderate or sensitive")
 		encCmd.Parse(os.Args[2:])
 
-		ks, _ := osm15.EncryptKey(*key, *pass)
+		opts, err := keystoreOptions(*kdf, *profile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		ks, err := osm15.EncryptKeyWithOptions(*key, *pass, opts)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 		fmt.Println(string(ks))
 
 	case "decrypt":
@@ -144,30 +326,737 @@ func main() {
 
 		data, _ := ioutil.ReadFile(*file)
 		key, err := osm15.DecryptKey(data, *pass)
-		if err != nil {
+		if errors.Is(err, osm15.ErrInvalidPassword) {
 			fmt.Println("Error: Invalid password")
 			os.Exit(1)
+		} else if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
 		}
 		fmt.Printf("Decrypted Private Key: %s\n", key)
 
+	case "migrate":
+		migCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+		file := migCmd.String("file", "", "Keystore file, rewritten in place")
+		pass := migCmd.String("pass", "", "Password (prompted if empty)")
+		migCmd.Parse(os.Args[2:])
+
+		if *file == "" {
+			fmt.Println("Usage: migrate -file <wallet.json> [-pass <pw>]")
+			os.Exit(1)
+		}
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		var ks osm15.Keystore
+		if err := json.Unmarshal(data, &ks); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if ks.Version >= osm15.KeystoreV3 {
+			fmt.Printf("%s is already version %d\n", *file, ks.Version)
+			return
+		}
+		if *pass == "" {
+			*pass = readPassword("Password for " + *file + ": ")
+		}
+		migrated, err := osm15.MigrateKeystore(data, *pass)
+		if errors.Is(err, osm15.ErrInvalidPassword) {
+			fmt.Println("Error: Invalid password")
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if err := writeFileAtomic(*file, migrated); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Migrated %s (%s) to keystore version %d\n", *file, ks.Address, osm15.KeystoreV3)
+
+	case "passwd":
+		pwCmd := flag.NewFlagSet("passwd", flag.ExitOnError)
+		wallet := pwCmd.String("wallet", "", "Keystore file, rewritten in place")
+		pass := pwCmd.String("pass", "", "Current password (prompted if empty)")
+		newPass := pwCmd.String("new-pass", "", "New password (prompted if empty)")
+		kdf := pwCmd.String("kdf", osm15.KDFArgon2id, "Key derivation function: argon2id or scrypt")
+		profile := pwCmd.String("profile", "interactive", "Cost profile: interactive, moderate or sensitive")
+		pwCmd.Parse(os.Args[2:])
+
+		if *wallet == "" {
+			fmt.Println("Usage: passwd -wallet <wallet.json> [-pass <old>] [-new-pass <new>] [-kdf argon2id|scrypt] [-profile <profile>]")
+			os.Exit(1)
+		}
+		// Without -kdf or -profile the current KDF and cost are kept.
+		opts := osm15.ReencryptOptions{KeepKDF: true}
+		pwCmd.Visit(func(f *flag.Flag) {
+			if f.Name == "kdf" || f.Name == "profile" {
+				kdfOpts, err := keystoreOptions(*kdf, *profile)
+				if err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+				opts = osm15.ReencryptOptions{KDF: kdfOpts}
+			}
+		})
+
+		data, err := ioutil.ReadFile(*wallet)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if *pass == "" {
+			*pass = readPassword("Current password for " + *wallet + ": ")
+		}
+		if *newPass == "" {
+			*newPass = readPassword("New password: ")
+			if readPassword("Repeat new password: ") != *newPass {
+				fmt.Println("Error: passwords do not match")
+				os.Exit(1)
+			}
+		}
+		if *newPass == "" {
+			fmt.Println("Error: new password is empty")
+			os.Exit(1)
+		}
+
+		// ReencryptKey checks the current password, so its key is derived once.
+		updated, err := osm15.ReencryptKey(data, *pass, *newPass, opts)
+		if errors.Is(err, osm15.ErrInvalidPassword) {
+			fmt.Println("Error: Invalid password")
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if err := writeFileAtomic(*wallet, updated); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Password changed for %s\n", *wallet)
+
+	case "explain":
+		explainCmd := flag.NewFlagSet("explain", flag.ExitOnError)
+		file := explainCmd.String("file", "", "TypedData JSON file")
+		asJSON := explainCmd.Bool("json", false, "Print the trace as JSON")
+		explainCmd.Parse(os.Args[2:])
+
+		if *file == "" {
+			fmt.Println("Usage: explain -file <data.json> [-json]")
+			os.Exit(1)
+		}
+
+		fileData, _ := ioutil.ReadFile(*file)
+		var typedData osm15.TypedData
+		if err := json.Unmarshal(fileData, &typedData); err != nil {
+			fmt.Println("Error: Invalid format")
+			os.Exit(1)
+		}
+
+		trace, err := osm15.Explain(typedData)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if *asJSON {
+			output, _ := json.MarshalIndent(trace, "", "  ")
+			fmt.Println(string(output))
+		} else {
+			fmt.Print(trace)
+		}
+
+	case "vectors":
+		vecCmd := flag.NewFlagSet("vectors", flag.ExitOnError)
+		inFile := vecCmd.String("in", "testdata/vectors.json", "Vector corpus to read inputs from")
+		outFile := vecCmd.String("out", "testdata/vectors.json", "Vector corpus to write")
+		vecCmd.Parse(os.Args[2:])
+
+		fileData, err := ioutil.ReadFile(*inFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		var corpus osm15.VectorFile
+		if err := json.Unmarshal(fileData, &corpus); err != nil {
+			fmt.Println("Error: Invalid format")
+			os.Exit(1)
+		}
+
+		for i, v := range corpus.Vectors {
+			generated, err := osm15.GenerateVector(v.Name, v.Data, v.Seed)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			corpus.Vectors[i] = generated
+		}
+		corpus.Version = osm15.VectorsVersion
+
+		output, _ := json.MarshalIndent(corpus, "", "  ")
+		if err := ioutil.WriteFile(*outFile, append(output, '\n'), 0644); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %d vectors to %s\n", len(corpus.Vectors), *outFile)
+
+	case "serve":
+		serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+		var wallets, passwords stringList
+		serveCmd.Var(&wallets, "wallet", "Keystore file (repeatable)")
+		serveCmd.Var(&passwords, "pass", "Keystore password (repeatable, or once for all wallets)")
+		addr := serveCmd.String("addr", "127.0.0.1:8915", "Listen address")
+		token := serveCmd.String("token", os.Getenv("OSM15_SERVE_TOKEN"), "API bearer token (default $OSM15_SERVE_TOKEN)")
+		policyFile := serveCmd.String("policy", "", "Signing policy file; requests it rejects get 403")
+		auditFile := serveCmd.String("audit", os.Getenv("OSM15_AUDIT_LOG"), "Audit log to record signatures in (default $OSM15_AUDIT_LOG)")
+		tlsCert := serveCmd.String("tls-cert", "", "TLS certificate file; required to listen beyond loopback")
+		tlsKey := serveCmd.String("tls-key", "", "TLS private key file")
+		serveCmd.Parse(os.Args[2:])
+
+		if len(wallets) == 0 || *token == "" || (len(passwords) != 1 && len(passwords) != len(wallets)) || (*tlsCert == "") != (*tlsKey == "") {
+			fmt.Println("Usage: serve -wallet <ks.json> [-wallet <ks2.json>] -pass <pw> [-pass <pw2>] -token <token> [-addr host:port] [-tls-cert <cert.pem> -tls-key <key.pem>]")
+			os.Exit(1)
+		}
+		// The bearer token must not cross the network in the clear.
+		if *tlsCert == "" && !isLoopback(*addr) {
+			fmt.Printf("Error: %s is not a loopback address; pass -tls-cert and -tls-key to serve on it\n", *addr)
+			os.Exit(1)
+		}
+
+		var signers []osm15.Signer
+		for i, walletFile := range wallets {
+			password := passwords[0]
+			if len(passwords) > 1 {
+				password = passwords[i]
+			}
+			ksData, err := ioutil.ReadFile(walletFile)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			signer, err := osm15.NewKeystoreSigner(ksData, password)
+			if err != nil {
+				fmt.Printf("Error: cannot unlock %s\n", walletFile)
+				os.Exit(1)
+			}
+			fmt.Printf("Unlocked %s\n", osm15.PublicKeyToAddress(signer.Public()))
+			signers = append(signers, signer)
+		}
+
+		srv, err := remote.NewServer(*token, signers...)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		srv.Policy = loadPolicy(*policyFile)
+		srv.AuditLog = openAuditLog(*auditFile)
+		defer srv.AuditLog.Close()
+		httpServer := &http.Server{Addr: *addr, Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+		fmt.Printf("Signing daemon listening on %s\n", *addr)
+		if *tlsCert != "" {
+			err = httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+	case "agent":
+		runAgent(os.Args[2:])
+
+	case "account":
+		runAccount(os.Args[2:])
+
+	case "verify-batch":
+		vbCmd := flag.NewFlagSet("verify-batch", flag.ExitOnError)
+		inDir := vbCmd.String("in", "", "Directory of signed payload files")
+		key := vbCmd.String("key", "", "Public key (base64) for version 1 payloads, which do not name their signer")
+		workers := vbCmd.Int("workers", 0, "Parallel workers (default: number of CPUs)")
+		cofactored := vbCmd.Bool("cofactored", false, "Batch-verify with the cofactored (ZIP-215) equation")
+		outFile := vbCmd.String("out", "", "Write the JSON report here instead of stdout")
+		vbCmd.Parse(os.Args[2:])
+
+		if *inDir == "" {
+			fmt.Println("Usage: verify-batch -in <dir> [-key <pub>] [-workers <n>] [-cofactored] [-out <report.json>]")
+			os.Exit(1)
+		}
+		files, err := ioutil.ReadDir(*inDir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		// Files that cannot be read as payloads are reported with the rest.
+		var items []osm15.BatchItem
+		var unreadable []osm15.BatchResult
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+			fileData, err := ioutil.ReadFile(filepath.Join(*inDir, f.Name()))
+			if err != nil {
+				unreadable = append(unreadable, osm15.BatchResult{ID: f.Name(), Error: err.Error()})
+				continue
+			}
+			payload, err := osm15.ParseSignedPayload(fileData)
+			if err != nil {
+				unreadable = append(unreadable, osm15.BatchResult{ID: f.Name(), Error: err.Error()})
+				continue
+			}
+			pub := payload.PublicKey
+			if pub == "" && *key == "" {
+				unreadable = append(unreadable, osm15.BatchResult{ID: f.Name(), Error: osm15.ErrNoPublicKey.Error() + "; pass -key"})
+				continue
+			}
+			if pub == "" {
+				pub = *key
+			} else if *key != "" && *key != pub {
+				unreadable = append(unreadable, osm15.BatchResult{ID: f.Name(), Address: payload.Address, Error: "signed by a different key than -key"})
+				continue
+			}
+			items = append(items, osm15.BatchItem{ID: f.Name(), Data: payload.Data, Signature: payload.Signature, PublicKey: pub})
+		}
+
+		report, err := osm15.VerifyBatch(context.Background(), items, osm15.BatchOptions{Workers: *workers, Cofactored: *cofactored})
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		report.Results = append(report.Results, unreadable...)
+		report.Total += len(unreadable)
+		report.Errors += len(unreadable)
+
+		output, _ := json.MarshalIndent(report, "", "  ")
+		if *outFile != "" {
+			if err := ioutil.WriteFile(*outFile, append(output, '\n'), 0644); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%d valid, %d invalid, %d errors of %d\n", report.Valid, report.Invalid, report.Errors, report.Total)
+		} else {
+			fmt.Println(string(output))
+		}
+		if report.Valid != report.Total {
+			os.Exit(1)
+		}
+
+	case "cosign":
+		cosignCmd := flag.NewFlagSet("cosign", flag.ExitOnError)
+		file := cosignCmd.String("file", "", "Signed file (multi-signature envelope, sign output or plain TypedData)")
+		outFile := cosignCmd.String("out", "", "Output file (default: rewrite -file)")
+		firstKey := cosignCmd.String("first-key", "", "Public key (base64) of the existing signature when -file is sign output")
+		walletFile := cosignCmd.String("wallet", "", "Keystore file (default: the agent at $OSM15_AUTH_SOCK)")
+		password := cosignCmd.String("pass", "", "Password")
+		address := cosignCmd.String("address", "", "Agent key to sign with")
+		auditFile := cosignCmd.String("audit", os.Getenv("OSM15_AUDIT_LOG"), "Audit log to record the signature in (default $OSM15_AUDIT_LOG)")
+		cosignCmd.Parse(os.Args[2:])
+
+		if *file == "" || (*walletFile == "" && os.Getenv(agent.EnvSocket) == "") {
+			fmt.Println("Usage: cosign -file <signed.json> -wallet <ks.json> [-pass <pw>] [-out <file>] [-first-key <pub>]")
+			os.Exit(1)
+		}
+		if *outFile == "" {
+			*outFile = *file
+		}
+
+		fileData, err := ioutil.ReadFile(*file)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		envelope, err := readEnvelope(fileData, *firstKey)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		signer := openSigner(*walletFile, *password, *address)
+		pubB64 := base64.StdEncoding.EncodeToString(signer.Public())
+		// Refuse a duplicate before signing, so the audit log does not
+		// record a signature that AddSignature would then drop.
+		if envelope.HasSigned(signer.Public()) {
+			fmt.Printf("Error: %s has already signed\n", osm15.PublicKeyToAddress(signer.Public()))
+			os.Exit(1)
+		}
+		auditLog := openAuditLog(*auditFile)
+		defer auditLog.Close()
+		sig, err := auditLog.Sign(context.Background(), envelope.Data, signer, nil, *file)
+		if err == nil {
+			err = envelope.AddSignature(pubB64, sig)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		output, _ := json.MarshalIndent(envelope, "", "  ")
+		if err := writeFileAtomic(*outFile, output); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added signature by %s (%d total) to %s\n", osm15.PublicKeyToAddress(signer.Public()), len(envelope.Signatures), *outFile)
+
+	case "audit":
+		if len(os.Args) >= 3 && os.Args[2] == "recover" {
+			recoverCmd := flag.NewFlagSet("audit recover", flag.ExitOnError)
+			logFile := recoverCmd.String("log", os.Getenv("OSM15_AUDIT_LOG"), "Audit log (default $OSM15_AUDIT_LOG)")
+			recoverCmd.Parse(os.Args[3:])
+			if *logFile == "" {
+				fmt.Println("Usage: audit recover -log <audit.log>")
+				os.Exit(1)
+			}
+			if _, err := audit.Recover(*logFile); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			head, err := audit.Verify(*logFile)
+			if err != nil {
+				fmt.Println("FAILED:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("OK: %d records, head %s\n", head.Seq, head.Hash)
+			return
+		}
+		if len(os.Args) < 3 || os.Args[2] != "verify" {
+			fmt.Println("Usage: audit verify -log <audit.log> [-head <hash>] | audit recover -log <audit.log>")
+			os.Exit(1)
+		}
+		auditCmd := flag.NewFlagSet("audit verify", flag.ExitOnError)
+		logFile := auditCmd.String("log", os.Getenv("OSM15_AUDIT_LOG"), "Audit log (default $OSM15_AUDIT_LOG)")
+		checkpoint := auditCmd.String("head", "", "Record hash saved earlier; the log must still contain it")
+		auditCmd.Parse(os.Args[3:])
+
+		if *logFile == "" {
+			fmt.Println("Usage: audit verify -log <audit.log> [-head <hash>]")
+			os.Exit(1)
+		}
+		head, err := audit.Verify(*logFile)
+		if err != nil {
+			fmt.Println("FAILED:", err)
+			os.Exit(1)
+		}
+		if *checkpoint != "" {
+			// Every record's hash but the last is the next record's Prev.
+			found := head.Hash == *checkpoint
+			records, _ := audit.Records(*logFile)
+			for _, r := range records {
+				if r.Seq > 1 && r.Prev == *checkpoint {
+					found = true
+				}
+			}
+			if !found {
+				fmt.Printf("FAILED: checkpoint %s is not in the log\n", *checkpoint)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("OK: %d records, head %s\n", head.Seq, head.Hash)
+		if *checkpoint == "" {
+			fmt.Println("Note: the hash chain is unkeyed; anyone who can write the log can rebuild it. Save the head hash elsewhere and pass it as -head later.")
+		}
+
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
 		os.Exit(1)
 	}
 }
 
-func processFile(filePath, outDir, privKey string) {
-	fileData, _ := ioutil.ReadFile(filePath)
+// Timing of watch-sign: how long a file must go without writes before it
+// is signed, and how long to wait before retrying a failure.
+const (
+	watchSettle = 500 * time.Millisecond
+	watchRetry  = 5 * time.Second
+)
+
+// processFile signs filePath into outDir. Files that are not valid typed
+// data or that the policy does not allow are rejected: with a policy they
+// are moved to rejectDir next to a .reason file, without one they are
+// skipped and left in place. Any other failure (reading the file, the
+// signer, the audit log, writing the output) is returned, leaving the file
+// in place to be tried again.
+func processFile(filePath, outDir, rejectDir string, signer osm15.Signer, policy *osm15.Policy, auditLog *audit.Log) error {
+	reject := func(reason string) {
+		if policy == nil {
+			fmt.Printf("Skip %s: %s\n", filePath, reason)
+			return
+		}
+		if err := rejectFile(filePath, rejectDir, reason); err != nil {
+			fmt.Printf("Reject %s: %s (could not move: %v)\n", filePath, reason, err)
+			return
+		}
+		fmt.Printf("Rejected %s: %s\n", filePath, reason)
+	}
+
+	fileData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
 	var typedData osm15.TypedData
 	if err := json.Unmarshal(fileData, &typedData); err != nil {
-		fmt.Printf("Skip %s: Invalid format\n", filePath)
-		return
+		reject("Invalid format: " + err.Error())
+		return nil
 	}
 
-	sig, _ := osm15.SignTypedData(typedData, privKey)
-	output, _ := osm15.ExportToJSON(typedData, sig)
-	
+	sig, err := auditLog.Sign(context.Background(), typedData, signer, policy, filePath)
+	if errors.Is(err, audit.ErrCorrupt) {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if err != nil && isRejection(err) {
+		reject(err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	output, err := osm15.ExportSignedPayload(typedData, sig, signer.Public())
+	if err != nil {
+		return err
+	}
 	outPath := filepath.Join(outDir, "signed_"+filepath.Base(filePath))
-	ioutil.WriteFile(outPath, output, 0644)
+	if err := writeFileAtomic(outPath, output); err != nil {
+		return fmt.Errorf("signed, but could not save %s: %w", outPath, err)
+	}
 	fmt.Printf("Signed and saved to %s\n", outPath)
+	return nil
+}
+
+// isRejection reports whether a signing error is about the data itself, a
+// policy violation or invalid typed data, so that retrying cannot help.
+func isRejection(err error) bool {
+	var schemaErr *osm15.SchemaError
+	return errors.Is(err, osm15.ErrPolicyViolation) || errors.As(err, &schemaErr) || osm15.ErrorCode(err) != "invalid"
+}
+
+// rejectFile moves filePath into rejectDir and records why in a
+// <name>.reason file beside it.
+func rejectFile(filePath, rejectDir, reason string) error {
+	if err := os.MkdirAll(rejectDir, 0755); err != nil {
+		return err
+	}
+	dest := filepath.Join(rejectDir, filepath.Base(filePath))
+	if err := ioutil.WriteFile(dest+".reason", []byte(reason+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(filePath, dest)
+}
+
+// readEnvelope accepts a multi-signature envelope, the single-signature
+// output of sign (version 1 output must name its signer with firstKey) or
+// unsigned TypedData.
+func readEnvelope(fileData []byte, firstKey string) (*osm15.MultiSignedPayload, error) {
+	var probe struct {
+		Data       *osm15.TypedData        `json:"data"`
+		Signature  string                  `json:"signature"`
+		PublicKey  string                  `json:"publicKey"`
+		Signatures []osm15.MultiSignature `json:"signatures"`
+	}
+	if err := json.Unmarshal(fileData, &probe); err != nil {
+		return nil, errors.New("invalid format")
+	}
+	if probe.Data == nil {
+		var data osm15.TypedData
+		if err := json.Unmarshal(fileData, &data); err != nil {
+			return nil, errors.New("invalid format")
+		}
+		return &osm15.MultiSignedPayload{Data: data}, nil
+	}
+
+	envelope := &osm15.MultiSignedPayload{Data: *probe.Data}
+	for _, s := range probe.Signatures {
+		if err := envelope.AddSignature(s.PublicKey, s.Signature); err != nil {
+			return nil, err
+		}
+	}
+	if probe.Signature != "" {
+		if firstKey == "" {
+			firstKey = probe.PublicKey
+		}
+		if firstKey == "" {
+			return nil, errors.New("the file has a signature without a public key; name its signer with -first-key")
+		}
+		if err := envelope.AddSignature(firstKey, probe.Signature); err != nil {
+			return nil, err
+		}
+	}
+	return envelope, nil
+}
+
+// writeFileAtomic replaces path with data, keeping the existing file's
+// permissions.
+func writeFileAtomic(path string, data []byte) error {
+	return atomicfile.WriteFile(path, data, atomicfile.Perm(path, 0644))
+}
+
+// openAuditLog opens the -audit log, if any. A nil log records nothing.
+func openAuditLog(path string) *audit.Log {
+	if path == "" {
+		return nil
+	}
+	auditLog, err := audit.Open(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return auditLog
+}
+
+// loadPolicy reads the -policy file, if any.
+func loadPolicy(path string) *osm15.Policy {
+	if path == "" {
+		return nil
+	}
+	policy, err := osm15.LoadPolicy(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return policy
+}
+
+// isLoopback reports whether addr only listens on the loopback interface.
+// An empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// mnemonicSigner derives the signer for a mnemonic, exiting on error.
+func mnemonicSigner(mnemonic, passphrase string) osm15.Signer {
+	privB64, err := osm15.KeyFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	signer, err := osm15.NewKeySignerFromBase64(privB64)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return signer
+}
+
+// keystoreOptions maps the -kdf and -profile flags to KeystoreOptions.
+func keystoreOptions(kdf, profile string) (osm15.KeystoreOptions, error) {
+	opts := osm15.KeystoreOptions{KDF: kdf}
+	switch profile {
+	case "interactive":
+		opts.Profile = osm15.ProfileInteractive
+	case "moderate":
+		opts.Profile = osm15.ProfileModerate
+	case "sensitive":
+		opts.Profile = osm15.ProfileSensitive
+	default:
+		return opts, fmt.Errorf("unknown profile %q (want interactive, moderate or sensitive)", profile)
+	}
+	return opts, nil
+}
+
+// openSigner unlocks walletFile, prompting for the password when it is
+// empty, or falls back to the agent at $OSM15_AUTH_SOCK when no wallet is
+// given. A walletFile that does not exist is looked up as an account
+// address or label in the keystore directory.
+func openSigner(walletFile, password, address string) osm15.Signer {
+	if walletFile == "" {
+		client, err := agent.DialEnv()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		signer, err := client.Signer(address)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return signer
+	}
+
+	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+		walletFile = findAccount(walletFile)
+	}
+	ksData, err := ioutil.ReadFile(walletFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if password == "" {
+		password = readPassword("Password for " + walletFile + ": ")
+	}
+	signer, err := osm15.NewKeystoreSigner(ksData, password)
+	if errors.Is(err, osm15.ErrInvalidPassword) {
+		fmt.Println("Error: Invalid password")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return signer
+}
+
+// findAccount resolves an account label or address in the keystore
+// directory, or returns name unchanged. The directory is only read, never
+// created, so a mistyped -wallet path still fails as a missing file.
+func findAccount(name string) string {
+	dir, err := wallet.DefaultDir()
+	if err != nil {
+		return name
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return name
+	}
+	w, err := wallet.Open(dir)
+	if err != nil {
+		return name
+	}
+	if a, err := w.Find(name); err == nil {
+		return a.Path
+	}
+	return name
+}
+
+// readPassword prompts on the terminal without echoing the input.
+func readPassword(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Println("Error: cannot read password:", err)
+		os.Exit(1)
+	}
+	return string(pw)
+}
+
+// readMnemonic prompts for a mnemonic without echoing it, or reads one
+// line from stdin when it is not a terminal.
+func readMnemonic() string {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return readPassword("Mnemonic: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println("Error: cannot read mnemonic:", err)
+		os.Exit(1)
+	}
+	return strings.TrimSpace(line)
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
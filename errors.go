@@ -0,0 +1,42 @@
+package osm15
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel causes wrapped by EncodeError. Use errors.Is to test for them.
+var (
+	ErrUnknownType  = errors.New("unknown type")
+	ErrTypeMismatch = errors.New("value does not match type")
+	ErrMissingField = errors.New("missing field")
+	ErrUnknownField = errors.New("undeclared field")
+	ErrOutOfRange   = errors.New("value out of range")
+)
+
+// EncodeError reports why a value could not be encoded. Path locates the
+// offending value inside the typed data, e.g. "message.orders[2].to".
+type EncodeError struct {
+	Path string
+	Type string
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("osm15: %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("osm15: %s (%s): %v", e.Path, e.Type, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+func mismatch(path, typeName string, value interface{}) error {
+	return &EncodeError{
+		Path: path,
+		Type: typeName,
+		Err:  fmt.Errorf("%w: got %T", ErrTypeMismatch, value),
+	}
+}
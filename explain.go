@@ -0,0 +1,108 @@
+package osm15
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Trace is the full hash tree behind a TypedData digest. Byte values are
+// hex encoded so two traces from different implementations can be diffed
+// directly, either as JSON or via String.
+type Trace struct {
+	Domain      *StructTrace `json:"domain"`
+	Message     *StructTrace `json:"message"`
+	SigningBody string       `json:"signingBody"`
+	Digest      string       `json:"digest"`
+}
+
+// StructTrace records how one struct value was hashed.
+type StructTrace struct {
+	Type        string        `json:"type"`
+	EncodedType string        `json:"encodedType"`
+	TypeHash    string        `json:"typeHash"`
+	Members     []*ValueTrace `json:"members"`
+	Hash        string        `json:"hash"`
+}
+
+// ValueTrace records the 32-byte encoding of a member or array element.
+// Struct is set for struct values and Elements for arrays.
+type ValueTrace struct {
+	Name     string        `json:"name,omitempty"`
+	Type     string        `json:"type"`
+	Encoded  string        `json:"encoded"`
+	Struct   *StructTrace  `json:"struct,omitempty"`
+	Elements []*ValueTrace `json:"elements,omitempty"`
+}
+
+// Explain hashes data like HashTypedData and returns every intermediate
+// value: type strings, type hashes, member encodings and struct hashes.
+func Explain(data TypedData) (*Trace, error) {
+	schema, err := CompileSchema(data.Types, data.PrimaryType)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.Domain.validate(); err != nil {
+		return nil, err
+	}
+
+	types := make(map[string][]TypedMember, len(schema.types)+1)
+	for name, members := range schema.types {
+		types[name] = members
+	}
+	types["TypedDomain"] = data.Domain.Members()
+	domainSchema, err := compileSchema(types, "TypedDomain")
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &Trace{Domain: &StructTrace{}, Message: &StructTrace{}}
+	domainHash, err := domainSchema.root.hash("domain", data.Domain.ToMap(), tr.Domain)
+	if err != nil {
+		return nil, err
+	}
+	messageHash, err := schema.root.hash("message", data.Message, tr.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	body := signingBody(domainHash, messageHash)
+	digest := sha256.Sum256(body)
+	tr.SigningBody = hex.EncodeToString(body)
+	tr.Digest = hex.EncodeToString(digest[:])
+	return tr, nil
+}
+
+// String renders the trace as an indented tree.
+func (t *Trace) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digest       %s\n", t.Digest)
+	fmt.Fprintf(&sb, "signingBody  %s\n", t.SigningBody)
+	sb.WriteString("domain\n")
+	writeStructTrace(&sb, t.Domain, 1)
+	sb.WriteString("message\n")
+	writeStructTrace(&sb, t.Message, 1)
+	return sb.String()
+}
+
+func writeStructTrace(sb *strings.Builder, st *StructTrace, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(sb, "%stype         %s\n", indent, st.EncodedType)
+	fmt.Fprintf(sb, "%stypeHash     %s\n", indent, st.TypeHash)
+	for _, m := range st.Members {
+		writeValueTrace(sb, m, m.Name, depth)
+	}
+	fmt.Fprintf(sb, "%shash         %s\n", indent, st.Hash)
+}
+
+func writeValueTrace(sb *strings.Builder, vt *ValueTrace, label string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(sb, "%s%s %s = %s\n", indent, label, vt.Type, vt.Encoded)
+	if vt.Struct != nil {
+		writeStructTrace(sb, vt.Struct, depth+1)
+	}
+	for i, el := range vt.Elements {
+		writeValueTrace(sb, el, fmt.Sprintf("[%d]", i), depth+1)
+	}
+}
@@ -3,9 +3,13 @@ module github.com/dayuwidayadi57/osm15
 go 1.25.1
 
 require (
+	filippo.io/edwards25519 v1.1.0
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/miekg/pkcs11 v1.1.2
 	github.com/mr-tron/base58 v1.2.0
 	golang.org/x/crypto v0.47.0
+	golang.org/x/sys v0.40.0
+	golang.org/x/text v0.33.0
 )
 
-require golang.org/x/sys v0.40.0 // indirect
+require golang.org/x/term v0.39.0
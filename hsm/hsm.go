@@ -0,0 +1,234 @@
+//go:build cgo
+
+// Package hsm provides an osm15.Signer for Ed25519 keys held in a PKCS#11
+// token, such as a network HSM or SoftHSM for local testing. Keys never
+// leave the token; digests are signed on it with CKM_EDDSA.
+package hsm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 v3.0 identifiers not yet exported by github.com/miekg/pkcs11.
+const (
+	ckkECEdwards = 0x00000040
+	ckmEdDSA     = 0x00001057
+)
+
+// Config locates an Ed25519 key pair in a PKCS#11 token.
+type Config struct {
+	// Module is the path of the PKCS#11 library, for example
+	// /usr/lib/softhsm/libsofthsm2.so.
+	Module string
+	// TokenLabel selects the token; empty uses the first slot with one.
+	TokenLabel string
+	// PIN is the user PIN of the token.
+	PIN string
+	// KeyLabel and KeyID match CKA_LABEL and CKA_ID of the key pair. At
+	// least one must be set.
+	KeyLabel string
+	KeyID    []byte
+}
+
+// Signer signs OSM-15 digests with a key held in a PKCS#11 token. It is
+// safe for concurrent use; signing operations are serialized on a single
+// session.
+type Signer struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	owner   bool // whether Open initialized the module and must finalize it
+	session pkcs11.SessionHandle
+	priv    pkcs11.ObjectHandle
+	pub     ed25519.PublicKey
+}
+
+var _ osm15.Signer = (*Signer)(nil)
+
+// Open loads the module, logs in to the token and locates the key pair.
+// Close must be called to release the session.
+func Open(cfg Config) (*Signer, error) {
+	if cfg.KeyLabel == "" && len(cfg.KeyID) == 0 {
+		return nil, errors.New("hsm: a key label or key ID is required")
+	}
+
+	p := pkcs11.New(cfg.Module)
+	if p == nil {
+		return nil, fmt.Errorf("hsm: cannot load PKCS#11 module %q", cfg.Module)
+	}
+	s := &Signer{ctx: p, owner: true}
+	if err := p.Initialize(); err != nil {
+		if !isCode(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+			p.Destroy()
+			return nil, fmt.Errorf("hsm: initialize: %w", err)
+		}
+		s.owner = false
+	}
+
+	if err := s.open(cfg); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Signer) open(cfg Config) error {
+	slot, err := findSlot(s.ctx, cfg.TokenLabel)
+	if err != nil {
+		return err
+	}
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("hsm: open session: %w", err)
+	}
+	if err := s.ctx.Login(s.session, pkcs11.CKU_USER, cfg.PIN); err != nil && !isCode(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return fmt.Errorf("hsm: login: %w", err)
+	}
+
+	s.priv, err = s.findKey(pkcs11.CKO_PRIVATE_KEY, cfg)
+	if err != nil {
+		return err
+	}
+	pubHandle, err := s.findKey(pkcs11.CKO_PUBLIC_KEY, cfg)
+	if err != nil {
+		return err
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, pubHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("hsm: read public key: %w", err)
+	}
+	s.pub, err = parseECPoint(attrs[0].Value)
+	return err
+}
+
+func findSlot(p *pkcs11.Ctx, tokenLabel string) (uint, error) {
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("hsm: list slots: %w", err)
+	}
+	for _, slot := range slots {
+		if tokenLabel == "" {
+			return slot, nil
+		}
+		info, err := p.GetTokenInfo(slot)
+		if err == nil && strings.TrimRight(info.Label, " \x00") == tokenLabel {
+			return slot, nil
+		}
+	}
+	if tokenLabel == "" {
+		return 0, errors.New("hsm: no token present")
+	}
+	return 0, fmt.Errorf("hsm: no token labelled %q", tokenLabel)
+}
+
+// findKey returns the single Ed25519 key object of class matching cfg.
+func (s *Signer) findKey(class uint, cfg Config) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+	}
+	if cfg.KeyLabel != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel))
+	}
+	if len(cfg.KeyID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, cfg.KeyID))
+	}
+
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, fmt.Errorf("hsm: find key: %w", err)
+	}
+	handles, _, err := s.ctx.FindObjects(s.session, 2)
+	if finalErr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("hsm: find key: %w", err)
+	}
+
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(handles) {
+	case 0:
+		return 0, fmt.Errorf("hsm: no Ed25519 %s key matches label %q id %x", kind, cfg.KeyLabel, cfg.KeyID)
+	case 1:
+		return handles[0], nil
+	}
+	return 0, fmt.Errorf("hsm: several Ed25519 %s keys match label %q id %x", kind, cfg.KeyLabel, cfg.KeyID)
+}
+
+// parseECPoint accepts CKA_EC_POINT as a DER OCTET STRING, as PKCS#11 v3.0
+// specifies, or as the raw 32-byte key some tokens return.
+func parseECPoint(point []byte) (ed25519.PublicKey, error) {
+	if len(point) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(bytes.Clone(point)), nil
+	}
+	if len(point) == ed25519.PublicKeySize+2 && point[0] == 0x04 && point[1] == ed25519.PublicKeySize {
+		return ed25519.PublicKey(bytes.Clone(point[2:])), nil
+	}
+	return nil, fmt.Errorf("hsm: unsupported CKA_EC_POINT encoding (%d bytes)", len(point))
+}
+
+// Public returns the Ed25519 public key of the token key.
+func (s *Signer) Public() ed25519.PublicKey {
+	return s.pub
+}
+
+// Address returns the Octra address of the token key.
+func (s *Signer) Address() string {
+	return osm15.PublicKeyToAddress(s.pub)
+}
+
+// SignDigest signs digest on the token with CKM_EDDSA.
+func (s *Signer) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(ckmEdDSA, nil)}
+	if err := s.ctx.SignInit(s.session, mech, s.priv); err != nil {
+		return nil, fmt.Errorf("hsm: sign init: %w", err)
+	}
+	sig, err := s.ctx.Sign(s.session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("hsm: sign: %w", err)
+	}
+	return sig, nil
+}
+
+// Close closes the session and unloads the module, finalizing it only if
+// Open was the one that initialized it.
+func (s *Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	if s.session != 0 {
+		s.ctx.CloseSession(s.session)
+	}
+	if s.owner {
+		s.ctx.Finalize()
+	}
+	s.ctx.Destroy()
+	s.ctx = nil
+	return nil
+}
+
+func isCode(err error, code uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == code
+}
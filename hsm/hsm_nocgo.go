@@ -0,0 +1,38 @@
+//go:build !cgo
+
+package hsm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+)
+
+// Config locates an Ed25519 key pair in a PKCS#11 token.
+type Config struct {
+	Module     string
+	TokenLabel string
+	PIN        string
+	KeyLabel   string
+	KeyID      []byte
+}
+
+// Signer is unavailable without cgo.
+type Signer struct{}
+
+var errNoCgo = errors.New("hsm: PKCS#11 support requires a cgo-enabled build")
+
+// Open always fails: loading a PKCS#11 module requires cgo.
+func Open(cfg Config) (*Signer, error) {
+	return nil, errNoCgo
+}
+
+func (s *Signer) Public() ed25519.PublicKey { return nil }
+
+func (s *Signer) Address() string { return "" }
+
+func (s *Signer) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return nil, errNoCgo
+}
+
+func (s *Signer) Close() error { return nil }
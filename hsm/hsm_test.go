@@ -0,0 +1,111 @@
+//go:build cgo
+
+package hsm
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/miekg/pkcs11"
+)
+
+// ckmECEdwardsKeyPairGen is CKM_EC_EDWARDS_KEY_PAIR_GEN from PKCS#11 v3.0.
+const ckmECEdwardsKeyPairGen = 0x00001055
+
+// ed25519Params is the DER encoding of the id-Ed25519 OID (1.3.101.112).
+var ed25519Params = []byte{0x06, 0x03, 0x2b, 0x65, 0x70}
+
+// Run against SoftHSM with, for example:
+//
+//	softhsm2-util --init-token --free --label osm15 --pin 1234 --so-pin 1234
+//	OSM15_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so OSM15_PKCS11_TOKEN=osm15 \
+//	OSM15_PKCS11_PIN=1234 go test ./hsm/
+func TestHSM_SoftHSMSign(t *testing.T) {
+	module := os.Getenv("OSM15_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("OSM15_PKCS11_MODULE not set")
+	}
+	cfg := Config{
+		Module:     module,
+		TokenLabel: os.Getenv("OSM15_PKCS11_TOKEN"),
+		PIN:        os.Getenv("OSM15_PKCS11_PIN"),
+		KeyLabel:   "osm15-test-" + t.Name(),
+	}
+	generateKey(t, cfg)
+
+	signer, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer signer.Close()
+
+	data := osm15.TypedData{
+		Domain:      osm15.TypedDomain{Name: "HSM", Version: "1", ChainID: 1},
+		Types:       map[string][]osm15.TypedMember{"Msg": {{Name: "text", Type: "string"}}},
+		PrimaryType: "Msg",
+		Message:     map[string]interface{}{"text": "signed on a token"},
+	}
+	// SignTypedDataWith verifies the signature against signer.Public().
+	if _, err := osm15.SignTypedDataWith(context.Background(), data, signer); err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if signer.Address() != osm15.PublicKeyToAddress(signer.Public()) {
+		t.Error("Address does not match the token public key")
+	}
+
+	if _, err := Open(Config{Module: module, TokenLabel: cfg.TokenLabel, PIN: cfg.PIN, KeyLabel: "missing"}); err == nil {
+		t.Error("Open found a key that does not exist")
+	}
+}
+
+// generateKey creates a session-only Ed25519 key pair labelled cfg.KeyLabel.
+// The module stays initialized until the test ends, so Open joins it.
+func generateKey(t *testing.T, cfg Config) {
+	t.Helper()
+	p := pkcs11.New(cfg.Module)
+	if p == nil {
+		t.Fatalf("Cannot load %s", cfg.Module)
+	}
+	if err := p.Initialize(); err != nil {
+		t.Fatalf("Initialize error: %v", err)
+	}
+	slot, err := findSlot(p, cfg.TokenLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatalf("OpenSession error: %v", err)
+	}
+	if err := p.Login(session, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		t.Fatalf("Login error: %v", err)
+	}
+
+	_, _, err = p.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(ckmECEdwardsKeyPairGen, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ed25519Params),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		})
+	if err != nil {
+		t.Fatalf("GenerateKeyPair error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		// Session objects disappear with the session that created them.
+		p.CloseSession(session)
+		p.Finalize()
+		p.Destroy()
+	})
+}
@@ -0,0 +1,180 @@
+package osm15
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxSafeFloat is the largest integer a float64 holds without rounding.
+const maxSafeFloat = 1 << 53
+
+var twoTo256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// decimalPattern matches JSON-style decimal numbers. The exponent is capped
+// so a hostile input cannot force a huge allocation.
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]{1,3})?$`)
+
+// intBounds holds the inclusive minimum and exclusive maximum of every
+// integer type, indexed by signedness and width in bytes.
+var intBounds = func() (b [2][33]struct{ min, max *big.Int }) {
+	for n := 1; n <= 32; n++ {
+		bits := uint(n * 8)
+		b[0][n].min = new(big.Int)
+		b[0][n].max = new(big.Int).Lsh(big.NewInt(1), bits)
+		b[1][n].max = new(big.Int).Lsh(big.NewInt(1), bits-1)
+		b[1][n].min = new(big.Int).Neg(b[1][n].max)
+	}
+	return b
+}()
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseIntType reports the bit width and signedness of an integer type name
+// such as "uint64" or "int256". The bare "uint", "int" and legacy "chainId"
+// names are 256 bits wide.
+func parseIntType(typeName string) (bits int, signed bool, ok bool) {
+	switch typeName {
+	case "uint", "chainId":
+		return 256, false, true
+	case "int":
+		return 256, true, true
+	}
+
+	digits := ""
+	switch {
+	case strings.HasPrefix(typeName, "uint"):
+		digits = typeName[4:]
+	case strings.HasPrefix(typeName, "int"):
+		digits, signed = typeName[3:], true
+	default:
+		return 0, false, false
+	}
+	if digits == "" || digits[0] == '0' {
+		return 0, false, false
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 8 || n > 256 || n%8 != 0 {
+		return 0, false, false
+	}
+	return n, signed, true
+}
+
+// encodeInteger range-checks value against the integer type and returns it
+// as a 32-byte big-endian two's-complement word.
+func encodeInteger(path, typeName string, bits int, signed bool, value interface{}) ([]byte, error) {
+	n, err := toBigInt(value)
+	if err != nil {
+		return nil, &EncodeError{Path: path, Type: typeName, Err: err}
+	}
+
+	bounds := intBounds[boolIndex(signed)][bits/8]
+	if n.Cmp(bounds.min) < 0 || n.Cmp(bounds.max) >= 0 {
+		return nil, &EncodeError{
+			Path: path,
+			Type: typeName,
+			Err:  fmt.Errorf("%w: %s", ErrOutOfRange, n),
+		}
+	}
+
+	if n.Sign() < 0 {
+		n = new(big.Int).Add(n, twoTo256)
+	}
+	word := make([]byte, 32)
+	n.FillBytes(word)
+	return word, nil
+}
+
+// toBigInt converts the accepted integer representations to a *big.Int:
+// Go integers, integral floats up to 2^53, json.Number, decimal or
+// 0x-prefixed hex strings, and big.Int values.
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case *big.Int:
+		if v == nil {
+			return nil, fmt.Errorf("%w: got nil *big.Int", ErrTypeMismatch)
+		}
+		return new(big.Int).Set(v), nil
+	case big.Int:
+		return new(big.Int).Set(&v), nil
+	case json.Number:
+		return parseInteger(string(v))
+	case string:
+		return parseInteger(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: %v is not an integer", ErrTypeMismatch, f)
+		}
+		if math.Abs(f) > maxSafeFloat {
+			return nil, fmt.Errorf("%w: %v exceeds float64 precision", ErrOutOfRange, f)
+		}
+		return big.NewInt(int64(f)), nil
+	}
+	return nil, fmt.Errorf("%w: got %T", ErrTypeMismatch, value)
+}
+
+// parseInteger parses a decimal (optionally with an exponent or a zero
+// fraction, as JSON numbers may have) or 0x-prefixed hex integer.
+func parseInteger(s string) (*big.Int, error) {
+	digits, neg := s, false
+	if strings.HasPrefix(digits, "-") {
+		digits, neg = digits[1:], true
+	}
+
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		n, ok := new(big.Int).SetString(digits[2:], 16)
+		if !ok || strings.HasPrefix(digits[2:], "-") || strings.HasPrefix(digits[2:], "+") {
+			return nil, fmt.Errorf("%w: invalid hex integer %q", ErrTypeMismatch, s)
+		}
+		if neg {
+			n.Neg(n)
+		}
+		return n, nil
+	}
+
+	if isDigits(digits) {
+		n, ok := new(big.Int).SetString(s, 10)
+		if ok {
+			return n, nil
+		}
+	}
+	if !decimalPattern.MatchString(s) {
+		return nil, fmt.Errorf("%w: invalid integer %q", ErrTypeMismatch, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || !r.IsInt() {
+		return nil, fmt.Errorf("%w: invalid integer %q", ErrTypeMismatch, s)
+	}
+	return new(big.Int).Set(r.Num()), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
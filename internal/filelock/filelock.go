@@ -0,0 +1,8 @@
+// Package filelock takes advisory locks on open files, so that processes
+// sharing a file take turns or keep out of each other's way.
+package filelock
+
+import "errors"
+
+// ErrLocked is returned by TryLock when another process holds the lock.
+var ErrLocked = errors.New("file is locked by another process")
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package filelock
+
+import "os"
+
+// Lock is a no-op on platforms without flock; only one process may use a
+// locked file at a time there.
+func Lock(f *os.File) error {
+	return nil
+}
+
+// TryLock is a no-op, like Lock.
+func TryLock(f *os.File) error {
+	return nil
+}
+
+func Unlock(f *os.File) error {
+	return nil
+}
@@ -5,8 +5,8 @@
  * Licensed under the MIT License
  * Version: 0.2.2
  *
- * This module handles secure keystore management using 
- * AES-256-GCM and Scrypt key derivation for the Octra network.
+ * This module handles secure keystore management using
+ * AES-256-GCM with Argon2id or Scrypt key derivation for the Octra network.
  */
 
 package osm15
@@ -14,91 +14,382 @@ package osm15
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/ed25519" 
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
+// Keystore format versions. Version 1 files have no version field and
+// always use scrypt; version 2 added Argon2id; version 3 authenticates the
+// header as GCM additional data.
+const (
+	KeystoreV1 = 1
+	KeystoreV2 = 2
+	KeystoreV3 = 3
+)
+
+// Key derivation functions.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+const (
+	cipherAES256GCM = "aes-256-gcm"
+	gcmNonceSize    = 12
+)
+
+// Bounds on the KDF parameters DecryptKey accepts. The minimums reject
+// files that were (or were tampered to be) trivially brute-forceable; the
+// maximums stop a hostile file from exhausting memory or CPU.
+const (
+	MinScryptN         = 1 << 14
+	MaxScryptN         = 1 << 20
+	MaxScryptR         = 16
+	MaxScryptP         = 16
+	MinArgon2Time      = 1
+	MaxArgon2Time      = 16
+	MinArgon2MemoryKiB = 16 * 1024
+	MaxArgon2MemoryKiB = 1024 * 1024
+	MaxArgon2Threads   = 16
+	MinSaltBytes       = 16
+	MaxSaltBytes       = 64
+
+	// maxKDFMemory bounds scrypt's 128*N*r bytes of working memory.
+	maxKDFMemory = 1 << 30
+)
+
+var (
+	// ErrInvalidPassword is returned when a keystore does not decrypt.
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrInvalidKeystore is wrapped by errors about malformed keystores,
+	// including KDF parameters outside the accepted bounds.
+	ErrInvalidKeystore = errors.New("invalid keystore")
+	// ErrAddressMismatch is returned when a keystore decrypts to a key
+	// other than the one its address field names.
+	ErrAddressMismatch = errors.New("keystore address does not match its key")
+)
+
 type Keystore struct {
+	Version int    `json:"version,omitempty"`
 	Address string `json:"address"`
 	Crypto  Crypto `json:"crypto"`
 }
 
 type Crypto struct {
-	Cipher     string `json:"cipher"`
-	CipherText string `json:"ciphertext"`
+	Cipher       string `json:"cipher"`
+	CipherText   string `json:"ciphertext"`
 	CipherParams struct {
 		IV string `json:"iv"`
 	} `json:"cipherparams"`
-	Kdf       string `json:"kdf"`
-	KdfParams struct {
-		N     int    `json:"n"`
-		R     int    `json:"r"`
-		P     int    `json:"p"`
-		Salt  string `json:"salt"`
-	} `json:"kdfparams"`
+	Kdf       string    `json:"kdf"`
+	KdfParams KDFParams `json:"kdfparams"`
 }
 
+// KDFParams holds the parameters of either KDF: N, R and P for scrypt;
+// Time, Memory (KiB) and P (threads) for Argon2id.
+type KDFParams struct {
+	N      int    `json:"n,omitempty"`
+	R      int    `json:"r,omitempty"`
+	P      int    `json:"p"`
+	Time   uint32 `json:"t,omitempty"`
+	Memory uint32 `json:"m,omitempty"`
+	Salt   string `json:"salt"`
+}
+
+// CostProfile selects how expensive a new keystore is to unlock.
+type CostProfile int
+
+const (
+	// ProfileInteractive takes well under a second on a laptop: Argon2id
+	// t=2 m=64MiB, or scrypt N=2^15 r=8 (the version 1 parameters).
+	ProfileInteractive CostProfile = iota
+	// ProfileModerate is for keys unlocked occasionally: Argon2id t=3
+	// m=256MiB, or scrypt N=2^17 r=8.
+	ProfileModerate
+	// ProfileSensitive is for cold storage: Argon2id t=4 m=1GiB, or
+	// scrypt N=2^20 r=8.
+	ProfileSensitive
+)
+
+// KeystoreOptions configures EncryptKeyWithOptions. The zero value means
+// Argon2id with ProfileInteractive.
+type KeystoreOptions struct {
+	KDF     string
+	Profile CostProfile
+}
+
+// kdfParams returns the parameters for opts, without the salt.
+func (opts KeystoreOptions) kdfParams() (string, KDFParams, error) {
+	kdf := opts.KDF
+	if kdf == "" {
+		kdf = KDFArgon2id
+	}
+	if opts.Profile < ProfileInteractive || opts.Profile > ProfileSensitive {
+		return "", KDFParams{}, fmt.Errorf("%w: unknown cost profile %d", ErrInvalidKeystore, opts.Profile)
+	}
+	switch kdf {
+	case KDFArgon2id:
+		profiles := []KDFParams{
+			{Time: 2, Memory: 64 * 1024, P: 1},
+			{Time: 3, Memory: 256 * 1024, P: 1},
+			{Time: 4, Memory: 1024 * 1024, P: 1},
+		}
+		return kdf, profiles[opts.Profile], nil
+	case KDFScrypt:
+		profiles := []KDFParams{
+			{N: 1 << 15, R: 8, P: 1},
+			{N: 1 << 17, R: 8, P: 1},
+			{N: 1 << 20, R: 8, P: 1},
+		}
+		return kdf, profiles[opts.Profile], nil
+	}
+	return "", KDFParams{}, fmt.Errorf("%w: unknown KDF %q", ErrInvalidKeystore, kdf)
+}
+
+// EncryptKey encrypts a base64 Ed25519 seed into a version 3 keystore
+// using Argon2id with ProfileInteractive.
 func EncryptKey(privateKeyB64 string, password string) ([]byte, error) {
+	return EncryptKeyWithOptions(privateKeyB64, password, KeystoreOptions{})
+}
+
+// EncryptKeyWithOptions is EncryptKey with a chosen KDF and cost profile.
+func EncryptKeyWithOptions(privateKeyB64 string, password string, opts KeystoreOptions) ([]byte, error) {
+	seed, err := base64.StdEncoding.DecodeString(privateKeyB64)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: private key must be a base64 %d-byte seed", ErrInvalidKey, ed25519.SeedSize)
+	}
+	kdf, params, err := opts.kdfParams()
+	if err != nil {
+		return nil, err
+	}
+	return encryptSeed(seed, password, kdf, params)
+}
+
+// ReencryptOptions configures ReencryptKey.
+type ReencryptOptions struct {
+	// KeepKDF keeps the keystore's KDF and parameters, and KDF is ignored.
+	KeepKDF bool
+	// KDF selects the new KDF and cost profile, exactly as for
+	// EncryptKeyWithOptions.
+	KDF KeystoreOptions
+}
+
+// MigrateKeystore rewrites a version 1 or 2 keystore as version 3, keeping
+// its password, KDF and cost but with a fresh salt and IV. Version 3
+// keystores are re-encrypted the same way.
+func MigrateKeystore(keystoreJSON []byte, password string) ([]byte, error) {
+	return ReencryptKey(keystoreJSON, password, password, ReencryptOptions{KeepKDF: true})
+}
+
+// ReencryptKey changes a keystore's password and writes it as version 3,
+// with the KDF chosen by opts. The old password is checked by decrypting,
+// so a wrong one returns ErrInvalidPassword. The seed is only held in
+// memory and is cleared before returning.
+func ReencryptKey(keystoreJSON []byte, oldPassword, newPassword string, opts ReencryptOptions) ([]byte, error) {
+	var kdf string
+	var params KDFParams
+	if opts.KeepKDF {
+		var ks Keystore
+		if err := json.Unmarshal(keystoreJSON, &ks); err != nil {
+			return nil, err
+		}
+		kdf, params = ks.Crypto.Kdf, ks.Crypto.KdfParams
+	} else {
+		var err error
+		if kdf, params, err = opts.KDF.kdfParams(); err != nil {
+			return nil, err
+		}
+	}
+
+	privateKeyB64, err := DecryptKey(keystoreJSON, oldPassword)
+	if err != nil {
+		return nil, err
+	}
 	seed, _ := base64.StdEncoding.DecodeString(privateKeyB64)
-	
+	defer clear(seed)
+	return encryptSeed(seed, newPassword, kdf, params)
+}
+
+// encryptSeed writes a version 3 keystore with the given KDF parameters
+// and a new random salt.
+func encryptSeed(seed []byte, password, kdf string, params KDFParams) ([]byte, error) {
 	// Generate Address from private key
 	priv := ed25519.NewKeyFromSeed(seed)
 	address := PublicKeyToAddress(priv.Public().(ed25519.PublicKey))
 
 	salt := make([]byte, 32)
-	io.ReadFull(rand.Reader, salt)
-
-	derivedKey, _ := scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
-
-	block, _ := aes.NewCipher(derivedKey)
-	gcm, _ := cipher.NewGCM(block)
-	iv := make([]byte, gcm.NonceSize())
-	io.ReadFull(rand.Reader, iv)
-
-	cipherText := gcm.Seal(nil, iv, seed, nil)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	params.Salt = base64.StdEncoding.EncodeToString(salt)
 
 	ks := Keystore{
-		Address: address, 
+		Version: KeystoreV3,
+		Address: address,
 		Crypto: Crypto{
-			Cipher:     "aes-256-gcm",
-			CipherText: base64.StdEncoding.EncodeToString(cipherText),
-			Kdf:        "scrypt",
+			Cipher:    cipherAES256GCM,
+			Kdf:       kdf,
+			KdfParams: params,
 		},
 	}
+	derivedKey, err := deriveKey(ks.Crypto, password)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	aad, err := ks.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	cipherText := gcm.Seal(nil, iv, seed, aad)
+
+	ks.Crypto.CipherText = base64.StdEncoding.EncodeToString(cipherText)
 	ks.Crypto.CipherParams.IV = base64.StdEncoding.EncodeToString(iv)
-	ks.Crypto.KdfParams.N = 32768
-	ks.Crypto.KdfParams.R = 8
-	ks.Crypto.KdfParams.P = 1
-	ks.Crypto.KdfParams.Salt = base64.StdEncoding.EncodeToString(salt)
 
 	return json.MarshalIndent(ks, "", "  ")
 }
 
+// DecryptKey decrypts a keystore of any version and returns the base64
+// seed. KDF parameters outside the Min/Max bounds are rejected before any
+// key derivation is attempted, and the address recorded in the file must
+// belong to the decrypted key.
+//
+// Version 3 authenticates the whole header, so an edited address, cipher
+// or KDF parameter fails to decrypt exactly like a wrong password. Older
+// versions only get the address check; use MigrateKeystore to upgrade them.
 func DecryptKey(keystoreJSON []byte, password string) (string, error) {
 	var ks Keystore
 	if err := json.Unmarshal(keystoreJSON, &ks); err != nil {
 		return "", err
 	}
+	switch ks.Version {
+	case 0, KeystoreV1:
+		if ks.Crypto.Kdf != KDFScrypt {
+			return "", fmt.Errorf("%w: version 1 keystores use scrypt, not %q", ErrInvalidKeystore, ks.Crypto.Kdf)
+		}
+	case KeystoreV2, KeystoreV3:
+	default:
+		return "", fmt.Errorf("%w: unsupported version %d", ErrInvalidKeystore, ks.Version)
+	}
+	if ks.Crypto.Cipher != cipherAES256GCM {
+		return "", fmt.Errorf("%w: unsupported cipher %q", ErrInvalidKeystore, ks.Crypto.Cipher)
+	}
 
-	salt, _ := base64.StdEncoding.DecodeString(ks.Crypto.KdfParams.Salt)
-	iv, _ := base64.StdEncoding.DecodeString(ks.Crypto.CipherParams.IV)
-	rawCipher, _ := base64.StdEncoding.DecodeString(ks.Crypto.CipherText)
-
-	derivedKey, _ := scrypt.Key([]byte(password), salt, ks.Crypto.KdfParams.N, ks.Crypto.KdfParams.R, ks.Crypto.KdfParams.P, 32)
+	iv, err := base64.StdEncoding.DecodeString(ks.Crypto.CipherParams.IV)
+	if err != nil {
+		return "", fmt.Errorf("%w: iv is not valid base64", ErrInvalidKeystore)
+	}
+	rawCipher, err := base64.StdEncoding.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return "", fmt.Errorf("%w: ciphertext is not valid base64", ErrInvalidKeystore)
+	}
+	if len(iv) != gcmNonceSize {
+		return "", fmt.Errorf("%w: iv must be %d bytes", ErrInvalidKeystore, gcmNonceSize)
+	}
 
-	block, _ := aes.NewCipher(derivedKey)
-	gcm, _ := cipher.NewGCM(block)
+	derivedKey, err := deriveKey(ks.Crypto, password)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(derivedKey)
+	if err != nil {
+		return "", err
+	}
 
-	seed, err := gcm.Open(nil, iv, rawCipher, nil)
+	var aad []byte
+	if ks.Version >= KeystoreV3 {
+		if aad, err = ks.additionalData(); err != nil {
+			return "", err
+		}
+	}
+	seed, err := gcm.Open(nil, iv, rawCipher, aad)
 	if err != nil {
-		return "", fmt.Errorf("invalid password")
+		return "", ErrInvalidPassword
+	}
+	if len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("%w: decrypted key has %d bytes", ErrInvalidKeystore, len(seed))
+	}
+	address := PublicKeyToAddress(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey))
+	if ks.Address != address {
+		return "", fmt.Errorf("%w: file says %q, key is %s", ErrAddressMismatch, ks.Address, address)
 	}
 
 	return base64.StdEncoding.EncodeToString(seed), nil
 }
+
+// additionalData is the GCM additional data of a version 3 keystore: every
+// header field, in a fixed order. The IV is authenticated by GCM itself.
+func (ks Keystore) additionalData() ([]byte, error) {
+	header, err := json.Marshal(struct {
+		Version   int       `json:"version"`
+		Address   string    `json:"address"`
+		Cipher    string    `json:"cipher"`
+		Kdf       string    `json:"kdf"`
+		KdfParams KDFParams `json:"kdfparams"`
+	}{ks.Version, ks.Address, ks.Crypto.Cipher, ks.Crypto.Kdf, ks.Crypto.KdfParams})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("osm15-keystore\x00"), header...), nil
+}
+
+// deriveKey checks the KDF parameters against the accepted bounds and
+// derives the 32-byte AES key.
+func deriveKey(c Crypto, password string) ([]byte, error) {
+	p := c.KdfParams
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil || len(salt) < MinSaltBytes || len(salt) > MaxSaltBytes {
+		return nil, fmt.Errorf("%w: salt must be %d to %d base64 bytes", ErrInvalidKeystore, MinSaltBytes, MaxSaltBytes)
+	}
+
+	switch c.Kdf {
+	case KDFScrypt:
+		if p.N < MinScryptN || p.N > MaxScryptN || p.N&(p.N-1) != 0 {
+			return nil, fmt.Errorf("%w: scrypt n=%d must be a power of two from %d to %d", ErrInvalidKeystore, p.N, MinScryptN, MaxScryptN)
+		}
+		if p.R < 1 || p.R > MaxScryptR || p.P < 1 || p.P > MaxScryptP {
+			return nil, fmt.Errorf("%w: scrypt r=%d p=%d out of range", ErrInvalidKeystore, p.R, p.P)
+		}
+		if 128*p.N*p.R > maxKDFMemory {
+			return nil, fmt.Errorf("%w: scrypt n=%d r=%d needs more than %d MiB", ErrInvalidKeystore, p.N, p.R, maxKDFMemory>>20)
+		}
+		return scrypt.Key([]byte(password), salt, p.N, p.R, p.P, 32)
+
+	case KDFArgon2id:
+		if p.Time < MinArgon2Time || p.Time > MaxArgon2Time {
+			return nil, fmt.Errorf("%w: argon2id t=%d must be from %d to %d", ErrInvalidKeystore, p.Time, MinArgon2Time, MaxArgon2Time)
+		}
+		if p.Memory < MinArgon2MemoryKiB || p.Memory > MaxArgon2MemoryKiB {
+			return nil, fmt.Errorf("%w: argon2id m=%d KiB must be from %d to %d", ErrInvalidKeystore, p.Memory, MinArgon2MemoryKiB, MaxArgon2MemoryKiB)
+		}
+		if p.P < 1 || p.P > MaxArgon2Threads {
+			return nil, fmt.Errorf("%w: argon2id p=%d must be from 1 to %d", ErrInvalidKeystore, p.P, MaxArgon2Threads)
+		}
+		return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, uint8(p.P), 32), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported KDF %q", ErrInvalidKeystore, c.Kdf)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
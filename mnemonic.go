@@ -0,0 +1,139 @@
+package osm15
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The BIP-39 English wordlist (SHA-256 2f5eed53...b24dbda), embedded so
+// mnemonics work offline.
+//
+//go:embed wordlists/english.txt
+var englishWordlist string
+
+var (
+	mnemonicWords = strings.Fields(englishWordlist)
+	mnemonicIndex = func() map[string]int {
+		index := make(map[string]int, len(mnemonicWords))
+		for i, w := range mnemonicWords {
+			index[w] = i
+		}
+		return index
+	}()
+)
+
+// ErrInvalidMnemonic is wrapped by errors about malformed mnemonics: an
+// unsupported length, a word outside the wordlist or a bad checksum.
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// GenerateMnemonic returns a new BIP-39 English mnemonic of 12, 15, 18, 21
+// or 24 words (128 to 256 bits of entropy).
+func GenerateMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", fmt.Errorf("%w: %d words (want 12, 15, 18, 21 or 24)", ErrInvalidMnemonic, words)
+	}
+	entropy := make([]byte, words*4/3)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// ValidateMnemonic checks the length, the words and the checksum of a
+// BIP-39 English mnemonic. Case and spacing are ignored.
+func ValidateMnemonic(mnemonic string) error {
+	_, err := mnemonicEntropy(mnemonic)
+	return err
+}
+
+// MnemonicToSeed validates mnemonic and returns its 64-byte BIP-39 seed:
+// PBKDF2-HMAC-SHA512 over the NFKD-normalized phrase, with 2048 rounds and
+// "mnemonic"+passphrase as salt.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := mnemonicEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	phrase := norm.NFKD.String(strings.Join(strings.Fields(strings.ToLower(mnemonic)), " "))
+	salt := norm.NFKD.String("mnemonic" + passphrase)
+	return pbkdf2.Key(sha512.New, phrase, []byte(salt), 2048, 64)
+}
+
+// KeyFromMnemonic derives the base64 Ed25519 seed for a mnemonic, for use
+// with NewKeySignerFromBase64 or EncryptKey. The key is the SLIP-0010
+// Ed25519 master key of the BIP-39 seed; the same phrase and passphrase
+// always give the same key.
+func KeyFromMnemonic(mnemonic, passphrase string) (string, error) {
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return "", err
+	}
+	key, chainCode := slip10Master(seed)
+	defer clear(seed)
+	defer clear(key)
+	clear(chainCode)
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// slip10Master returns the SLIP-0010 Ed25519 master key and chain code.
+func slip10Master(seed []byte) (key, chainCode []byte) {
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	return sum[:32], sum[32:]
+}
+
+// entropyToMnemonic appends the SHA-256 checksum (one bit per 32 bits of
+// entropy) and maps each 11 bits to a word.
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := len(entropy) / 4
+	hash := sha256.Sum256(entropy)
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, uint(checksumBits))
+	n.Or(n, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words := make([]string, (len(entropy)*8+checksumBits)/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = mnemonicWords[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// mnemonicEntropy reverses entropyToMnemonic and checks the checksum.
+func mnemonicEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words (want 12, 15, 18, 21 or 24)", ErrInvalidMnemonic, len(words))
+	}
+	n := new(big.Int)
+	for i, w := range words {
+		index, ok := mnemonicIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("%w: word %d (%q) is not in the wordlist", ErrInvalidMnemonic, i+1, w)
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) / 3
+	checksum := new(big.Int).And(n, big.NewInt(1<<checksumBits-1)).Int64()
+	n.Rsh(n, uint(checksumBits))
+	entropy := n.FillBytes(make([]byte, len(words)*4/3))
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, fmt.Errorf("%w: checksum does not match", ErrInvalidMnemonic)
+	}
+	return entropy, nil
+}
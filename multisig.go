@@ -0,0 +1,165 @@
+package osm15
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrThresholdNotMet is returned by VerifyThreshold when fewer than m
+// allowed keys produced valid signatures.
+var ErrThresholdNotMet = errors.New("signature threshold not met")
+
+// MultiSignature is one co-signer's signature. Both fields are base64.
+type MultiSignature struct {
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+// MultiSignedPayload carries typed data with any number of signatures
+// over the same digest, for M-of-N approval.
+type MultiSignedPayload struct {
+	Data       TypedData        `json:"data"`
+	Signatures []MultiSignature `json:"signatures"`
+}
+
+// AddSignature appends a signature made elsewhere. It is checked against
+// the payload's digest first; a key may sign only once.
+func (p *MultiSignedPayload) AddSignature(publicKeyB64, signatureB64 string) error {
+	pub := decodePublicKey(publicKeyB64)
+	if pub == nil {
+		return fmt.Errorf("%w: public key must be a base64 Ed25519 key", ErrInvalidKey)
+	}
+	if p.HasSigned(pub) {
+		return fmt.Errorf("osm15: %s has already signed", PublicKeyToAddress(pub))
+	}
+	valid, err := VerifyTypedData(p.Data, signatureB64, publicKeyB64)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return fmt.Errorf("osm15: signature by %s: %w", publicKeyAddress(publicKeyB64), ErrBadSignature)
+	}
+	p.Signatures = append(p.Signatures, MultiSignature{PublicKey: publicKeyB64, Signature: signatureB64})
+	return nil
+}
+
+// HasSigned reports whether the payload holds a signature by pub. Keys are
+// compared decoded, like in AddSignature.
+func (p *MultiSignedPayload) HasSigned(pub ed25519.PublicKey) bool {
+	for _, s := range p.Signatures {
+		if other := decodePublicKey(s.PublicKey); other != nil && pub.Equal(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign signs the payload with signer and appends the signature. A key
+// that has already signed is refused before signer is asked to sign.
+func (p *MultiSignedPayload) Sign(ctx context.Context, signer Signer) error {
+	if p.HasSigned(signer.Public()) {
+		return fmt.Errorf("osm15: %s has already signed", PublicKeyToAddress(signer.Public()))
+	}
+	sig, err := SignTypedDataWith(ctx, p.Data, signer)
+	if err != nil {
+		return err
+	}
+	return p.AddSignature(base64.StdEncoding.EncodeToString(signer.Public()), sig)
+}
+
+// SignerResult is the outcome for one signature in a MultiSignedPayload.
+type SignerResult struct {
+	PublicKey string `json:"publicKey"`
+	Address   string `json:"address"`
+	Allowed   bool   `json:"allowed"`
+	Valid     bool   `json:"valid"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ThresholdResult reports which signers counted towards a threshold.
+type ThresholdResult struct {
+	Signers   []SignerResult `json:"signers"`
+	Valid     int            `json:"valid"`
+	Threshold int            `json:"threshold"`
+	Met       bool           `json:"met"`
+}
+
+// VerifyThreshold checks every signature in payload and reports whether
+// at least m distinct keys from allowedKeys (base64 public keys) signed
+// it. Signatures from keys outside allowedKeys, duplicates and invalid
+// signatures are reported but not counted. Keys are compared by their
+// decoded bytes, so two base64 spellings of one key are the same signer.
+// The result is returned even when the threshold is not met, together
+// with ErrThresholdNotMet.
+func VerifyThreshold(payload MultiSignedPayload, allowedKeys []string, m int) (*ThresholdResult, error) {
+	allowed := make(map[string]bool, len(allowedKeys))
+	for i, k := range allowedKeys {
+		pub := decodePublicKey(k)
+		if pub == nil {
+			return nil, fmt.Errorf("%w: allowed key %d is not a base64 Ed25519 key", ErrInvalidKey, i+1)
+		}
+		allowed[string(pub)] = true
+	}
+	if m < 1 || m > len(allowed) {
+		return nil, fmt.Errorf("osm15: threshold %d is not between 1 and %d", m, len(allowed))
+	}
+	digest, err := HashTypedData(payload.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ThresholdResult{Threshold: m}
+	counted := make(map[string]bool)
+	for _, s := range payload.Signatures {
+		pub := decodePublicKey(s.PublicKey)
+		r := SignerResult{PublicKey: s.PublicKey, Address: publicKeyAddress(s.PublicKey), Allowed: pub != nil && allowed[string(pub)]}
+		sig, sigErr := base64.StdEncoding.DecodeString(s.Signature)
+		switch {
+		case pub == nil:
+			r.Reason = "malformed public key"
+		case sigErr != nil || len(sig) != ed25519.SignatureSize:
+			r.Reason = "malformed signature"
+		case !ed25519.Verify(pub, digest, sig):
+			r.Reason = "signature does not verify"
+		default:
+			r.Valid = true
+		}
+		switch {
+		case r.Valid && !r.Allowed:
+			r.Reason = "key is not in the allowed set"
+		case r.Valid && counted[string(pub)]:
+			r.Reason = "duplicate signature"
+		case r.Valid:
+			counted[string(pub)] = true
+			res.Valid++
+		}
+		res.Signers = append(res.Signers, r)
+	}
+	res.Met = res.Valid >= m
+	if !res.Met {
+		return res, fmt.Errorf("%w: %d of %d", ErrThresholdNotMet, res.Valid, m)
+	}
+	return res, nil
+}
+
+// publicKeyAddress is PublicKeyToAddress for a base64 key, or "" if it
+// does not decode to an Ed25519 key.
+func publicKeyAddress(publicKeyB64 string) string {
+	pub := decodePublicKey(publicKeyB64)
+	if pub == nil {
+		return ""
+	}
+	return PublicKeyToAddress(pub)
+}
+
+// decodePublicKey decodes a base64 Ed25519 public key, or returns nil.
+func decodePublicKey(publicKeyB64 string) ed25519.PublicKey {
+	pub, err := base64.StdEncoding.DecodeString(publicKeyB64)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil
+	}
+	return pub
+}
@@ -15,12 +15,13 @@ package osm15
 
 import (
     "bytes"
+    "context"
     "crypto/ed25519"
     "crypto/sha256"
     "encoding/base64"
     "encoding/json"
+    "errors"
     "fmt"
-    "reflect"
     "sort"
     "strings"
 
@@ -32,10 +33,28 @@ type TypedMember struct {
     Type string `json:"type"`
 }
 
+// TypedDomain separates signatures between applications. Only the fields
+// that are set take part in the domain type, so the type string of a domain
+// with a verifying contract differs from one without, as in EIP-712.
+//
+// ChainID is encoded as uint256, but 0 means unset: a domain with ChainID 0
+// has no chainId field at all. Chains are therefore numbered from 1, and
+// ids must fit in an int.
 type TypedDomain struct {
-    Name    string `json:"name"`
-    Version string `json:"version"`
-    ChainID int    `json:"chainId"`
+    Name              string        `json:"name,omitempty"`
+    Version           string        `json:"version,omitempty"`
+    ChainID           int           `json:"chainId,omitempty"`
+    VerifyingContract string        `json:"verifyingContract,omitempty"`
+    Salt              string        `json:"salt,omitempty"`
+    Extensions        []DomainField `json:"extensions,omitempty"`
+}
+
+// DomainField is an application-defined domain field. Extensions follow
+// the standard fields in the domain type, in the order given.
+type DomainField struct {
+    Name  string      `json:"name"`
+    Type  string      `json:"type"`
+    Value interface{} `json:"value"`
 }
 
 type TypedData struct {
@@ -45,37 +64,105 @@ type TypedData struct {
     Message     map[string]interface{}   `json:"message"`
 }
 
+// Members returns the domain type derived from the fields that are set.
+func (d TypedDomain) Members() []TypedMember {
+    var members []TypedMember
+    if d.Name != "" { members = append(members, TypedMember{Name: "name", Type: "string"}) }
+    if d.Version != "" { members = append(members, TypedMember{Name: "version", Type: "string"}) }
+    if d.ChainID != 0 { members = append(members, TypedMember{Name: "chainId", Type: "uint256"}) }
+    if d.VerifyingContract != "" { members = append(members, TypedMember{Name: "verifyingContract", Type: "address"}) }
+    if d.Salt != "" { members = append(members, TypedMember{Name: "salt", Type: "bytes32"}) }
+    for _, ext := range d.Extensions {
+        members = append(members, TypedMember{Name: ext.Name, Type: ext.Type})
+    }
+    return members
+}
+
 func (d TypedDomain) ToMap() map[string]interface{} {
-    return map[string]interface{}{
-        "name":    d.Name,
-        "version": d.Version,
-        "chainId": d.ChainID,
+    m := make(map[string]interface{})
+    if d.Name != "" { m["name"] = d.Name }
+    if d.Version != "" { m["version"] = d.Version }
+    if d.ChainID != 0 { m["chainId"] = d.ChainID }
+    if d.VerifyingContract != "" { m["verifyingContract"] = d.VerifyingContract }
+    if d.Salt != "" { m["salt"] = d.Salt }
+    for _, ext := range d.Extensions {
+        m[ext.Name] = ext.Value
+    }
+    return m
+}
+
+// validate checks the chain id and the verifying contract address, and
+// that extensions are atomic fields with identifier names that do not
+// redeclare a field.
+func (d TypedDomain) validate() error {
+    if d.ChainID < 0 {
+        return &EncodeError{Path: "domain.chainId", Type: "uint256", Err: fmt.Errorf("%w: %d is negative", ErrOutOfRange, d.ChainID)}
+    }
+    if d.VerifyingContract != "" {
+        if err := ValidateAddress(d.VerifyingContract); err != nil {
+            return &EncodeError{Path: "domain.verifyingContract", Type: "address", Err: err}
+        }
+    }
+    seen := map[string]bool{"name": true, "version": true, "chainId": true, "verifyingContract": true, "salt": true}
+    for i, ext := range d.Extensions {
+        path := fmt.Sprintf("domain.extensions[%d]", i)
+        if !identifierPattern.MatchString(ext.Name) || seen[ext.Name] {
+            return &EncodeError{
+                Path: path,
+                Type: ext.Type,
+                Err:  fmt.Errorf("%w: duplicate or invalid name %q", ErrUnknownField, ext.Name),
+            }
+        }
+        if !isPrimitiveType(ext.Type) {
+            return &EncodeError{
+                Path: path,
+                Type: ext.Type,
+                Err:  fmt.Errorf("%w: domain fields must have an atomic type", ErrUnknownType),
+            }
+        }
+        seen[ext.Name] = true
     }
+    return nil
 }
 
+// UnmarshalJSON decodes message numbers as json.Number so that integers
+// beyond 2^53 keep their exact value.
+func (d *TypedData) UnmarshalJSON(b []byte) error {
+    type plain TypedData
+    dec := json.NewDecoder(bytes.NewReader(b))
+    dec.UseNumber()
+    return dec.Decode((*plain)(d))
+}
+
+// SignedPayload is typed data with its signature. Version 2 payloads also
+// name the signer through PublicKey, Address and Algorithm so receivers
+// need no out-of-band key lookup; version 1 payloads (no version field)
+// carry only Data and Signature. See ParseSignedPayload.
 type SignedPayload struct {
+    Version   int       `json:"version,omitempty"`
+    Algorithm string    `json:"algorithm,omitempty"`
+    PublicKey string    `json:"publicKey,omitempty"`
+    Address   string    `json:"address,omitempty"`
     Data      TypedData `json:"data"`
     Signature string    `json:"signature"`
 }
 
 func HashTypedData(data TypedData) ([]byte, error) {
-    if data.Types == nil {
-        data.Types = make(map[string][]TypedMember)
-    }
-    
-    data.Types["TypedDomain"] = []TypedMember{
-        {Name: "name", Type: "string"},
-        {Name: "version", Type: "string"},
-        {Name: "chainId", Type: "uint256"},
-    }
-
-    domainHash, err := hashStruct("TypedDomain", data.Domain.ToMap(), data.Types)
+    schema, err := CompileSchema(data.Types, data.PrimaryType)
     if err != nil { return nil, err }
-    
-    messageHash, err := hashStruct(data.PrimaryType, data.Message, data.Types)
+    return schema.Hash(data.Domain, data.Message)
+}
+
+// encodeSigningBody builds the prefixed domain+message payload that is
+// hashed by HashTypedData and shown by GetSigningText.
+func encodeSigningBody(data TypedData) ([]byte, error) {
+    schema, err := CompileSchema(data.Types, data.PrimaryType)
     if err != nil { return nil, err }
+    return schema.signingBody(data.Domain, data.Message)
+}
 
-    payloadBinary := append(domainHash, messageHash...)
+func signingBody(domainHash, messageHash []byte) []byte {
+    payloadBinary := append(append([]byte(nil), domainHash...), messageHash...)
     
     const TypedPrefix = "\x19Octra Typed Data:\n"
     
@@ -85,68 +172,7 @@ func HashTypedData(data TypedData) ([]byte, error) {
         len(payloadBinary), 
         string(payloadBinary),
     )
-
-    hash := sha256.Sum256([]byte(signingBody))
-    return hash[:], nil
-}
-
-func hashStruct(typeName string, data map[string]interface{}, types map[string][]TypedMember) ([]byte, error) {
-    typeString := encodeType(typeName, types)
-    typeHash := sha256.Sum256([]byte(typeString))
-    
-    var buf bytes.Buffer
-    buf.Write(typeHash[:])
-
-    members := types[typeName]
-    for _, member := range members {
-        val := data[member.Name]
-        encodedVal, err := encodeValue(member.Type, val, types)
-        if err != nil { return nil, err }
-        buf.Write(encodedVal)
-    }
-
-    finalHash := sha256.Sum256(buf.Bytes())
-    return finalHash[:], nil
-}
-
-func encodeValue(typeName string, value interface{}, types map[string][]TypedMember) ([]byte, error) {
-    if strings.HasSuffix(typeName, "[]") {
-        baseType := typeName[:len(typeName)-2]
-        rv := reflect.ValueOf(value)
-        if rv.Kind() != reflect.Slice {
-            return nil, fmt.Errorf("expected slice for type %s", typeName)
-        }
-        var buf bytes.Buffer
-        for i := 0; i < rv.Len(); i++ {
-            encoded, err := encodeValue(baseType, rv.Index(i).Interface(), types)
-            if err != nil { return nil, err }
-            buf.Write(encoded)
-        }
-        hash := sha256.Sum256(buf.Bytes())
-        return hash[:], nil
-    }
-
-    if _, ok := types[typeName]; ok {
-        mapVal, _ := value.(map[string]interface{})
-        return hashStruct(typeName, mapVal, types)
-    }
-
-    var data []byte
-    switch typeName {
-    case "string":
-        s, _ := value.(string)
-        h := sha256.Sum256([]byte(s))
-        return h[:], nil
-    case "address":
-        s, _ := value.(string)
-        data = []byte(s)
-    case "uint256", "int", "chainId":
-        data = []byte(fmt.Sprintf("%v", value))
-    default:
-        data, _ = json.Marshal(value)
-    }
-    h := sha256.Sum256(data)
-    return h[:], nil
+    return []byte(signingBody)
 }
 
 func encodeType(primaryType string, types map[string][]TypedMember) string {
@@ -169,8 +195,7 @@ func findDependencies(primaryType string, types map[string][]TypedMember, found
     if _, ok := types[primaryType]; !ok { return found }
     found[primaryType] = true
     for _, member := range types[primaryType] {
-        cleanType := strings.TrimSuffix(member.Type, "[]")
-        findDependencies(cleanType, types, found)
+        findDependencies(baseType(member.Type), types, found)
     }
     return found
 }
@@ -187,20 +212,26 @@ func formatMainType(name string, types map[string][]TypedMember) string {
     return sb.String()
 }
 
+// SignTypedData signs data with a base64 Ed25519 seed. Prefer
+// SignTypedDataWith and a Signer so application code never holds the seed.
 func SignTypedData(data TypedData, privateKeyB64 string) (string, error) {
-    digest, err := HashTypedData(data)
+    signer, err := NewKeySignerFromBase64(privateKeyB64)
     if err != nil { return "", err }
-    seed, _ := base64.StdEncoding.DecodeString(privateKeyB64)
-    priv := ed25519.NewKeyFromSeed(seed)
-    sig := ed25519.Sign(priv, digest)
-    return base64.StdEncoding.EncodeToString(sig), nil
+    return SignTypedDataWith(context.Background(), data, signer)
 }
 
+// VerifyTypedData reports whether signatureB64 is a valid signature of
+// data by publicKeyB64. A malformed signature is simply invalid; a public
+// key that is not a base64 Ed25519 key is an error wrapping ErrInvalidKey.
 func VerifyTypedData(data TypedData, signatureB64 string, publicKeyB64 string) (bool, error) {
     digest, err := HashTypedData(data)
     if err != nil { return false, err }
-    sig, _ := base64.StdEncoding.DecodeString(signatureB64)
-    pk, _ := base64.StdEncoding.DecodeString(publicKeyB64)
+    pk, err := base64.StdEncoding.DecodeString(publicKeyB64)
+    if err != nil || len(pk) != ed25519.PublicKeySize {
+        return false, fmt.Errorf("%w: public key must be a base64 %d-byte Ed25519 key", ErrInvalidKey, ed25519.PublicKeySize)
+    }
+    sig, err := base64.StdEncoding.DecodeString(signatureB64)
+    if err != nil { return false, nil }
     return ed25519.Verify(pk, digest, sig), nil
 }
 
@@ -209,6 +240,21 @@ func PublicKeyToAddress(publicKey []byte) string {
     return "oct" + base58.Encode(hash[:])
 }
 
+// ValidateAddress checks that addr is an "oct" prefix followed by the
+// base58 encoding of a 32-byte hash, as produced by PublicKeyToAddress.
+func ValidateAddress(addr string) error {
+    if !strings.HasPrefix(addr, "oct") {
+        return fmt.Errorf("%w: address %q lacks the oct prefix", ErrTypeMismatch, addr)
+    }
+    raw, err := base58.Decode(addr[len("oct"):])
+    if err != nil || len(raw) != sha256.Size {
+        return fmt.Errorf("%w: malformed address %q", ErrTypeMismatch, addr)
+    }
+    return nil
+}
+
+// ExportToJSON writes a version 1 payload, which does not name its signer.
+// Prefer ExportSignedPayload.
 func ExportToJSON(data TypedData, signature string) ([]byte, error) {
     payload := SignedPayload{
         Data:      data,
@@ -217,12 +263,17 @@ func ExportToJSON(data TypedData, signature string) ([]byte, error) {
     return json.MarshalIndent(payload, "", "  ")
 }
 
+// VerifyFromJSON verifies a version 1 or 2 payload against publicKeyB64,
+// which is required; a version 2 payload must also name that key. To
+// verify a payload by the key it embeds, use SignedPayload.Verify, which
+// returns the signer's address.
 func VerifyFromJSON(payloadJSON []byte, publicKeyB64 string) (bool, error) {
-    var payload SignedPayload
-    if err := json.Unmarshal(payloadJSON, &payload); err != nil {
-        return false, err
-    }
-    return VerifyTypedData(payload.Data, payload.Signature, publicKeyB64)
+    payload, err := ParseSignedPayload(payloadJSON)
+    if err != nil { return false, err }
+    pub, err := payload.signerKey(publicKeyB64)
+    if errors.Is(err, ErrBadSignature) { return false, nil }
+    if err != nil { return false, err }
+    return VerifyTypedData(payload.Data, payload.Signature, pub)
 }
 
 func GetSignerAddress(data TypedData, signatureB64 string, publicKeyB64 string) (string, error) {
@@ -235,12 +286,9 @@ func GetSignerAddress(data TypedData, signatureB64 string, publicKeyB64 string)
 }
 
 func GetSigningText(data TypedData) (string, error) {
-    domainHash, _ := hashStruct("TypedDomain", data.Domain.ToMap(), data.Types)
-    messageHash, _ := hashStruct(data.PrimaryType, data.Message, data.Types)
-    payloadBinary := append(domainHash, messageHash...)
-    
-    const TypedPrefix = "\x19Octra Typed Data:\n"
-    return fmt.Sprintf("%s%d\n%s", TypedPrefix, len(payloadBinary), string(payloadBinary)), nil
+    signingBody, err := encodeSigningBody(data)
+    if err != nil { return "", err }
+    return string(signingBody), nil
 }
 
 func GenerateKeypair() (string, string, error) {
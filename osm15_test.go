@@ -1,11 +1,23 @@
 package osm15
 
 import (
+	"bytes"
+	"context"
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15/internal/filelock"
 )
 
 func TestOSM15_ArrayAndRecursiveDebug(t *testing.T) {
@@ -28,7 +40,7 @@ func TestOSM15_ArrayAndRecursiveDebug(t *testing.T) {
 
 	// Message dengan Array of Structs
 	message := map[string]interface{}{
-		"owner": "oct123",
+		"owner": "octCCYa6DM7NuL4iPoSM4PvtYMRcHFydKhD5oRSXx3jfxCS",
 		"assets": []map[string]interface{}{
 			{"name": "OCT", "amount": 1000},
 			{"name": "GOLD", "amount": 50},
@@ -64,7 +76,7 @@ func TestOSM15_ArrayAndRecursiveDebug(t *testing.T) {
 
 	// 4. Test Tamper Resistance (Ubah isi array sedikit saja)
 	tamperedMessage := map[string]interface{}{
-		"owner": "oct123",
+		"owner": "octCCYa6DM7NuL4iPoSM4PvtYMRcHFydKhD5oRSXx3jfxCS",
 		"assets": []map[string]interface{}{
 			{"name": "OCT", "amount": 1001}, // Beda 1 unit
 			{"name": "GOLD", "amount": 50},
@@ -212,3 +224,1516 @@ func TestOSM15_CoverageBooster(t *testing.T) {
     valid, err := VerifyFromJSON(jsonBytes, pub)
     if !valid || err != nil { t.Error("VerifyFromJSON failed") }
 }
+
+func TestOSM15_StrictEncoding(t *testing.T) {
+	types := map[string][]TypedMember{
+		"Order": {
+			{Name: "to", Type: "address"},
+			{Name: "amount", Type: "uint256"},
+		},
+		"Batch": {{Name: "orders", Type: "Order[]"}},
+	}
+	base := func(msg map[string]interface{}) TypedData {
+		return TypedData{
+			Domain:      TypedDomain{Name: "Strict", Version: "1", ChainID: 1},
+			Types:       types,
+			PrimaryType: "Batch",
+			Message:     msg,
+		}
+	}
+	order := func(to interface{}, amount interface{}) map[string]interface{} {
+		return map[string]interface{}{"to": to, "amount": amount}
+	}
+
+	// 1. Valid message still hashes
+	good := base(map[string]interface{}{"orders": []interface{}{order("oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", 10)}})
+	if _, err := HashTypedData(good); err != nil {
+		t.Fatalf("Valid message rejected: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		msg  map[string]interface{}
+		path string
+		want error
+	}{
+		{"malformed address", map[string]interface{}{"orders": []interface{}{order("oct1", 1)}}, "message.orders[0].to", ErrTypeMismatch},
+		{"number as address", map[string]interface{}{"orders": []interface{}{order("oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", 1), order("octFJKTv1un7qsnyKdwKez7B67JJp3oCU5ntCVXcRsWEjtg", 2), order(42, 3)}}, "message.orders[2].to", ErrTypeMismatch},
+		{"string as uint256", map[string]interface{}{"orders": []interface{}{order("oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "ten")}}, "message.orders[0].amount", ErrTypeMismatch},
+		{"missing field", map[string]interface{}{"orders": []interface{}{map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG"}}}, "message.orders[0].amount", ErrMissingField},
+		{"extra field", map[string]interface{}{"orders": []interface{}{}, "memo": "hi"}, "message.memo", ErrUnknownField},
+		{"not a slice", map[string]interface{}{"orders": order("oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", 1)}, "message.orders", ErrTypeMismatch},
+	}
+	for _, tc := range cases {
+		_, err := HashTypedData(base(tc.msg))
+		var encErr *EncodeError
+		if !errors.As(err, &encErr) {
+			t.Errorf("%s: expected *EncodeError, got %v", tc.name, err)
+			continue
+		}
+		if encErr.Path != tc.path || !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+
+	// 2. Unknown primitive type fails closed for signing and verification
+	typo := TypedData{
+		Domain:      TypedDomain{Name: "Strict", Version: "1", ChainID: 1},
+		Types:       map[string][]TypedMember{"Msg": {{Name: "amount", Type: "uint265"}}},
+		PrimaryType: "Msg",
+		Message:     map[string]interface{}{"amount": 1},
+	}
+	priv, pub, _ := GenerateKeypair()
+	var schemaErr *SchemaError
+	if _, err := SignTypedData(typo, priv); !errors.As(err, &schemaErr) {
+		t.Errorf("Sign should reject unknown type, got %v", err)
+	}
+	if valid, err := VerifyTypedData(typo, "", pub); valid || !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Verify should reject unknown type, got %v", err)
+	}
+}
+
+func TestOSM15_IntegerEncoding(t *testing.T) {
+	hashAmount := func(typ string, amount interface{}) ([]byte, error) {
+		return HashTypedData(TypedData{
+			Domain:      TypedDomain{Name: "Ints", Version: "1", ChainID: 1},
+			Types:       map[string][]TypedMember{"Pay": {{Name: "amount", Type: typ}}},
+			PrimaryType: "Pay",
+			Message:     map[string]interface{}{"amount": amount},
+		})
+	}
+
+	// 1. Equivalent representations hash identically
+	want, err := hashAmount("uint256", 1000)
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+	for _, v := range []interface{}{1000.0, "1000", "0x3e8", json.Number("1000"), json.Number("1e3"), uint16(1000), big.NewInt(1000), int64(1000)} {
+		got, err := hashAmount("uint256", v)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Representation %T(%v) hashed differently: %v", v, v, err)
+		}
+	}
+
+	// 2. Values above 2^53 survive a JSON round trip
+	big64, _ := new(big.Int).SetString("18446744073709551615", 10)
+	data := TypedData{
+		Domain:      TypedDomain{Name: "Ints", Version: "1", ChainID: 1},
+		Types:       map[string][]TypedMember{"Pay": {{Name: "amount", Type: "uint64"}}},
+		PrimaryType: "Pay",
+		Message:     map[string]interface{}{"amount": big64},
+	}
+	direct, _ := HashTypedData(data)
+	raw, _ := json.Marshal(data)
+	var decoded TypedData
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	roundTrip, err := HashTypedData(decoded)
+	if err != nil || !bytes.Equal(direct, roundTrip) {
+		t.Errorf("Large integer changed after JSON round trip: %v", err)
+	}
+
+	// 3. Range and representation checks
+	rejects := []struct {
+		typ   string
+		value interface{}
+		want  error
+	}{
+		{"uint8", 256, ErrOutOfRange},
+		{"uint256", -1, ErrOutOfRange},
+		{"int8", -129, ErrOutOfRange},
+		{"int8", 128, ErrOutOfRange},
+		{"uint256", 1.5, ErrTypeMismatch},
+		{"uint256", 1e300, ErrOutOfRange},
+		{"uint256", "0b101", ErrTypeMismatch},
+		{"uint256", true, ErrTypeMismatch},
+		{"uint7", 1, ErrUnknownType},
+		{"int264", 1, ErrUnknownType},
+	}
+	for _, tc := range rejects {
+		if _, err := hashAmount(tc.typ, tc.value); !errors.Is(err, tc.want) {
+			t.Errorf("%s(%v): expected %v, got %v", tc.typ, tc.value, tc.want, err)
+		}
+	}
+	if _, err := hashAmount("int8", -128); err != nil {
+		t.Errorf("int8 lower bound rejected: %v", err)
+	}
+}
+
+func TestOSM15_BoolAndBytes(t *testing.T) {
+	hashValue := func(typ string, v interface{}) ([]byte, error) {
+		return HashTypedData(TypedData{
+			Domain:      TypedDomain{Name: "Bytes", Version: "1", ChainID: 1},
+			Types:       map[string][]TypedMember{"Doc": {{Name: "value", Type: typ}}},
+			PrimaryType: "Doc",
+			Message:     map[string]interface{}{"value": v},
+		})
+	}
+
+	// 1. Hex, base64, slices and arrays of the same bytes agree
+	raw := sha256.Sum256([]byte("content"))
+	for _, typ := range []string{"bytes", "bytes32"} {
+		want, err := hashValue(typ, raw[:])
+		if err != nil {
+			t.Fatalf("%s hash error: %v", typ, err)
+		}
+		for _, v := range []interface{}{raw, "0x" + hex.EncodeToString(raw[:]), base64.StdEncoding.EncodeToString(raw[:])} {
+			got, err := hashValue(typ, v)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("%s: %T representation hashed differently: %v", typ, v, err)
+			}
+		}
+	}
+
+	// 2. bool is distinct per value and strict about its Go type
+	yes, _ := hashValue("bool", true)
+	no, _ := hashValue("bool", false)
+	if bytes.Equal(yes, no) {
+		t.Error("true and false hash identically")
+	}
+
+	rejects := []struct {
+		typ   string
+		value interface{}
+		want  error
+	}{
+		{"bool", "true", ErrTypeMismatch},
+		{"bool", 1, ErrTypeMismatch},
+		{"bytes4", "0x010203", ErrOutOfRange},
+		{"bytes4", "0x0102030405", ErrOutOfRange},
+		{"bytes", "0xzz", ErrTypeMismatch},
+		{"bytes", "not base64!", ErrTypeMismatch},
+		{"bytes33", "0x01", ErrUnknownType},
+		{"bytes0", "0x", ErrUnknownType},
+	}
+	for _, tc := range rejects {
+		if _, err := hashValue(tc.typ, tc.value); !errors.Is(err, tc.want) {
+			t.Errorf("%s(%v): expected %v, got %v", tc.typ, tc.value, tc.want, err)
+		}
+	}
+}
+
+func TestOSM15_FixedAndNestedArrays(t *testing.T) {
+	types := map[string][]TypedMember{
+		"Settlement": {
+			{Name: "matrix", Type: "Transfer[2][]"},
+			{Name: "signers", Type: "address[][]"},
+		},
+		"Transfer": {
+			{Name: "to", Type: "address"},
+			{Name: "amount", Type: "uint64"},
+		},
+	}
+	transfer := func(to string, amount int) map[string]interface{} {
+		return map[string]interface{}{"to": to, "amount": amount}
+	}
+	data := TypedData{
+		Domain:      TypedDomain{Name: "Settle", Version: "1", ChainID: 1},
+		Types:       types,
+		PrimaryType: "Settlement",
+		Message: map[string]interface{}{
+			"matrix": []interface{}{
+				[]interface{}{transfer("oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", 1), transfer("octFJKTv1un7qsnyKdwKez7B67JJp3oCU5ntCVXcRsWEjtg", 2)},
+				[2]map[string]interface{}{transfer("oct6FbDRScGruVdATaNWzD51xJkTfYCVwxSZDb7gzqCLzwf", 3), transfer("oct64J4UGtfZqfnvxWCwU1aSMN62xqxLiS61iEPuD9JWxAm", 4)},
+			},
+			"signers": [][]string{{"oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG"}, {"octFJKTv1un7qsnyKdwKez7B67JJp3oCU5ntCVXcRsWEjtg", "oct6FbDRScGruVdATaNWzD51xJkTfYCVwxSZDb7gzqCLzwf"}},
+		},
+	}
+
+	// 1. Nested types resolve their struct dependencies
+	if got := encodeType("Settlement", types); got != "Settlement(Transfer[2][] matrix,address[][] signers)Transfer(address to,uint64 amount)" {
+		t.Errorf("Unexpected type string: %s", got)
+	}
+	digest, err := HashTypedData(data)
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+	fmt.Printf("\n[DEBUG] Matrix Digest: %s\n", hex.EncodeToString(digest))
+
+	// 2. Fixed length is enforced
+	data.Message["matrix"] = []interface{}{[]interface{}{transfer("oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", 1)}}
+	_, err = HashTypedData(data)
+	var encErr *EncodeError
+	if !errors.As(err, &encErr) || encErr.Path != "message.matrix[0]" || !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("Short fixed array accepted: %v", err)
+	}
+
+	// 3. Malformed array types are rejected
+	for _, typ := range []string{"Transfer[x]", "Transfer[", "[]", "Transfer[01]", "Transfer]"} {
+		types["Settlement"][0].Type = typ
+		if _, err := HashTypedData(data); !errors.Is(err, ErrUnknownType) {
+			t.Errorf("%s: expected ErrUnknownType, got %v", typ, err)
+		}
+	}
+}
+
+func TestOSM15_DomainFields(t *testing.T) {
+	_, pubA, _ := GenerateKeypair()
+	_, pubB, _ := GenerateKeypair()
+	rawA, _ := base64.StdEncoding.DecodeString(pubA)
+	rawB, _ := base64.StdEncoding.DecodeString(pubB)
+	contractA, contractB := PublicKeyToAddress(rawA), PublicKeyToAddress(rawB)
+
+	types := map[string][]TypedMember{"Mail": {{Name: "content", Type: "string"}}}
+	msg := map[string]interface{}{"content": "hello"}
+	withDomain := func(d TypedDomain) TypedData {
+		return TypedData{Domain: d, Types: types, PrimaryType: "Mail", Message: msg}
+	}
+
+	// 1. The domain type follows the fields that are set
+	domain := TypedDomain{Name: "App", Version: "1", ChainID: 1, VerifyingContract: contractA}
+	if got := encodeType("TypedDomain", map[string][]TypedMember{"TypedDomain": domain.Members()}); got != "TypedDomain(string name,string version,uint256 chainId,address verifyingContract)" {
+		t.Errorf("Unexpected domain type: %s", got)
+	}
+
+	// 2. Same name/version/chain but different contracts do not share a domain
+	digestA, err := HashTypedData(withDomain(domain))
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+	domain.VerifyingContract = contractB
+	digestB, _ := HashTypedData(withDomain(domain))
+	if bytes.Equal(digestA, digestB) {
+		t.Error("Domain isolation failed across verifying contracts")
+	}
+	if _, ok := types["TypedDomain"]; ok {
+		t.Error("HashTypedData mutated the caller's Types")
+	}
+
+	// 3. Salt and application-defined fields are part of the domain
+	salted := domain
+	salted.Salt = "0x" + strings.Repeat("ab", 32)
+	salted.Extensions = []DomainField{{Name: "region", Type: "string", Value: "eu"}}
+	digestC, err := HashTypedData(withDomain(salted))
+	if err != nil || bytes.Equal(digestB, digestC) {
+		t.Errorf("Salted domain not separated: %v", err)
+	}
+
+	rejects := []TypedDomain{
+		{Name: "App", Salt: "0x01"},
+		{Name: "App", Extensions: []DomainField{{Name: "limit", Type: "uint8", Value: 300}}},
+	}
+	for i, d := range rejects {
+		if _, err := HashTypedData(withDomain(d)); err == nil {
+			t.Errorf("Domain %d should be rejected", i)
+		}
+	}
+
+	// 4. Malformed domains fail Validate too: extensions need identifier
+	// names and atomic types, and chain ids cannot be negative
+	invalid := []TypedDomain{
+		{Name: "App", VerifyingContract: "oct123"},
+		{Name: "App", Extensions: []DomainField{{Name: "name", Type: "string", Value: "x"}}},
+		{Name: "App", Extensions: []DomainField{{Name: "my region", Type: "string", Value: "eu"}}},
+		{Name: "App", Extensions: []DomainField{{Name: "region)", Type: "string", Value: "eu"}}},
+		{Name: "App", Extensions: []DomainField{{Name: "mail", Type: "Mail", Value: msg}}},
+		{Name: "App", Extensions: []DomainField{{Name: "tags", Type: "string[]", Value: []interface{}{"a"}}}},
+		{Name: "App", ChainID: -1},
+	}
+	for i, d := range invalid {
+		if _, err := HashTypedData(withDomain(d)); err == nil {
+			t.Errorf("Invalid domain %d should be rejected", i)
+		}
+		if diags := withDomain(d).Validate(); len(diags) != 1 || diags[0].Code != DiagInvalidDomain {
+			t.Errorf("Invalid domain %d: Validate = %v", i, diags)
+		}
+	}
+}
+
+type testTransfer struct {
+	To     string   `osm15:"to,address"`
+	Amount *big.Int `osm15:"amount,uint256"`
+	Memo   []byte   `osm15:"memo"`
+}
+
+type testBatch struct {
+	Owner     string          `osm15:"owner,address"`
+	Nonce     uint64          `osm15:"nonce"`
+	Urgent    bool            `osm15:"urgent"`
+	Hash      [32]byte        `osm15:"hash"`
+	Transfers []testTransfer  `osm15:"transfers,Transfer[]"`
+	Pair      [2]testTransfer `osm15:"pair,Transfer[2]"`
+	internal  string
+	Ignored   string `osm15:"-"`
+}
+
+func TestOSM15_StructBuilder(t *testing.T) {
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	tr := testTransfer{To: "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", Amount: amount, Memo: []byte("rent")}
+	in := testBatch{
+		Owner:     "oct7TTGKXuhDL4XHeo2J2ZfKijhY4J8wYhPMHagzdUh6ZSQ",
+		Nonce:     1 << 60,
+		Urgent:    true,
+		Hash:      sha256.Sum256([]byte("x")),
+		Transfers: []testTransfer{tr},
+		Pair:      [2]testTransfer{tr, tr},
+		internal:  "skip",
+		Ignored:   "skip",
+	}
+	domain := TypedDomain{Name: "Structs", Version: "1", ChainID: 1}
+
+	// 1. Types and message are derived together
+	data, err := FromStruct(domain, &in)
+	if err != nil {
+		t.Fatalf("FromStruct error: %v", err)
+	}
+	want := "testBatch(address owner,uint64 nonce,bool urgent,bytes32 hash,Transfer[] transfers,Transfer[2] pair)Transfer(address to,uint256 amount,bytes memo)"
+	if got := encodeType(data.PrimaryType, data.Types); got != want {
+		t.Errorf("Unexpected type string: %s", got)
+	}
+	if _, ok := data.Message["internal"]; ok {
+		t.Error("Unexported field leaked into message")
+	}
+
+	// 2. Sign, export, import and decode back into the struct
+	priv, pub, _ := GenerateKeypair()
+	sig, err := SignTypedData(data, priv)
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	jsonBytes, _ := ExportToJSON(data, sig)
+	var payload SignedPayload
+	if err := json.Unmarshal(jsonBytes, &payload); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if valid, err := VerifyTypedData(payload.Data, payload.Signature, pub); !valid || err != nil {
+		t.Fatalf("Round-tripped payload failed verification: %v", err)
+	}
+
+	var out testBatch
+	if err := Decode(payload.Data, &out); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if out.Nonce != in.Nonce || out.Hash != in.Hash || !out.Urgent ||
+		out.Pair[1].Amount.Cmp(amount) != 0 || string(out.Transfers[0].Memo) != "rent" {
+		t.Errorf("Decoded struct differs: %+v", out)
+	}
+
+	// 3. Decoding into a struct with another schema is refused
+	var wrong testTransfer
+	if err := Decode(payload.Data, &wrong); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("Expected ErrSchemaMismatch, got %v", err)
+	}
+}
+
+func TestOSM15_ValidateTypes(t *testing.T) {
+	types := map[string][]TypedMember{
+		"Order": {
+			{Name: "to", Type: "address"},
+			{Name: "to", Type: "uint256"},
+			{Name: "item", Type: "Item"},
+			{Name: "bad", Type: "Thing[x]"},
+		},
+		"Node":  {{Name: "next", Type: "Link[]"}},
+		"Link":  {{Name: "node", Type: "Node"}},
+		"Empty": {},
+		// Written back by older releases; must be ignored
+		"TypedDomain": {{Name: "name", Type: "string"}},
+	}
+
+	got := map[string]string{}
+	for _, d := range ValidateTypes(types) {
+		got[d.Path] = d.Code
+		fmt.Printf("[DEBUG] %s\n", d)
+	}
+	want := map[string]string{
+		"types.Order[1]": DiagDuplicateMember,
+		"types.Order[2]": DiagUndefinedType,
+		"types.Order[3]": DiagInvalidType,
+		"types.Empty":    DiagEmptyStruct,
+		"types.Link":     DiagCyclicType,
+	}
+	for path, code := range want {
+		if got[path] != code {
+			t.Errorf("%s: expected %s, got %q", path, code, got[path])
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Unexpected diagnostics: %v", got)
+	}
+
+	// Missing primary type is reported and blocks signing
+	priv, _, _ := GenerateKeypair()
+	data := TypedData{
+		Domain:  TypedDomain{Name: "Validate", Version: "1", ChainID: 1},
+		Types:   map[string][]TypedMember{"Msg": {{Name: "text", Type: "string"}}},
+		Message: map[string]interface{}{"text": "hi"},
+	}
+	if diags := data.Validate(); len(diags) != 1 || diags[0].Code != DiagMissingPrimaryType {
+		t.Errorf("Expected missing primary type, got %v", diags)
+	}
+	var schemaErr *SchemaError
+	if _, err := SignTypedData(data, priv); !errors.As(err, &schemaErr) {
+		t.Errorf("Sign should fail validation, got %v", err)
+	}
+	data.PrimaryType = "Msg"
+	if diags := data.Validate(); len(diags) != 0 {
+		t.Errorf("Valid data reported %v", diags)
+	}
+}
+
+func benchOrderData() TypedData {
+	orders := make([]interface{}, 16)
+	for i := range orders {
+		orders[i] = map[string]interface{}{
+			"to":     PublicKeyToAddress([]byte(fmt.Sprint(i))),
+			"amount": json.Number("1000000000000000000"),
+			"memo":   "settlement",
+		}
+	}
+	return TypedData{
+		Domain: TypedDomain{Name: "Relayer", Version: "1", ChainID: 1},
+		Types: map[string][]TypedMember{
+			"Batch": {{Name: "owner", Type: "address"}, {Name: "orders", Type: "Order[]"}},
+			"Order": {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}, {Name: "memo", Type: "string"}},
+		},
+		PrimaryType: "Batch",
+		Message:     map[string]interface{}{"owner": "oct7TTGKXuhDL4XHeo2J2ZfKijhY4J8wYhPMHagzdUh6ZSQ", "orders": orders},
+	}
+}
+
+func TestOSM15_CompiledSchema(t *testing.T) {
+	data := benchOrderData()
+	want, err := HashTypedData(data)
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+
+	// 1. Compiled schema matches the one-shot path
+	schema, err := CompileSchema(data.Types, data.PrimaryType)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	for i := 0; i < 2; i++ { // second pass hits the domain cache
+		got, err := schema.Hash(data.Domain, data.Message)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("Schema hash mismatch: %v", err)
+		}
+	}
+	other := data.Domain
+	other.Name = "Other"
+	if got, _ := schema.Hash(other, data.Message); bytes.Equal(got, want) {
+		t.Error("Domain cache ignored a different domain")
+	}
+	for i := 0; i < 100; i++ { // caller-chosen values do not grow the cache
+		other.Name = fmt.Sprintf("Other%d", i)
+		other.Extensions = []DomainField{{Name: fmt.Sprintf("x%d", i), Type: "string", Value: "v"}}
+		schema.Hash(other, data.Message)
+	}
+	cached := 0
+	schema.domains.Range(func(k, v interface{}) bool { cached++; return true })
+	if cached != 1 {
+		t.Errorf("Domain cache holds %d entries, want 1", cached)
+	}
+
+	// 2. Registry shares one schema across goroutines
+	var reg Registry
+	first, _ := reg.Compile(data.Types, data.PrimaryType)
+	if again, _ := reg.Compile(data.Types, data.PrimaryType); again != first {
+		t.Error("Registry compiled the same schema twice")
+	}
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			got, err := reg.HashTypedData(data)
+			if err == nil && !bytes.Equal(got, want) {
+				err = fmt.Errorf("digest mismatch")
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Concurrent hash failed: %v", err)
+		}
+	}
+
+	// 3. Unknown member types fail at compile time
+	if _, err := CompileSchema(map[string][]TypedMember{"M": {{Name: "x", Type: "uint265"}}}, "M"); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Expected ErrUnknownType, got %v", err)
+	}
+}
+
+func BenchmarkHashTypedData(b *testing.B) {
+	data := benchOrderData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := HashTypedData(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSchemaHash(b *testing.B) {
+	data := benchOrderData()
+	schema, err := CompileSchema(data.Types, data.PrimaryType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := schema.Hash(data.Domain, data.Message); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegistryHashParallel(b *testing.B) {
+	data := benchOrderData()
+	var reg Registry
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := reg.HashTypedData(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestOSM15_Explain(t *testing.T) {
+	data := benchOrderData()
+	data.Message["orders"] = data.Message["orders"].([]interface{})[:2]
+
+	tr, err := Explain(data)
+	if err != nil {
+		t.Fatalf("Explain error: %v", err)
+	}
+	digest, _ := HashTypedData(data)
+	if tr.Digest != hex.EncodeToString(digest) {
+		t.Errorf("Trace digest %s differs from HashTypedData", tr.Digest)
+	}
+	fmt.Printf("\n[DEBUG] Trace:\n%s", tr)
+
+	// The tree reaches down to array elements of nested structs
+	orders := tr.Message.Members[1]
+	if orders.Name != "orders" || len(orders.Elements) != 2 || orders.Elements[1].Struct == nil {
+		t.Fatalf("Unexpected orders trace: %+v", orders)
+	}
+	order := orders.Elements[1].Struct
+	if order.EncodedType != "Order(address to,uint256 amount,string memo)" || order.Hash != orders.Elements[1].Encoded {
+		t.Errorf("Unexpected order trace: %+v", order)
+	}
+	amount := order.Members[1].Encoded
+	if amount != fmt.Sprintf("%064x", big.NewInt(1000000000000000000)) {
+		t.Errorf("Unexpected amount encoding: %s", amount)
+	}
+
+	// Traces survive JSON serialization
+	raw, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	var back Trace
+	if err := json.Unmarshal(raw, &back); err != nil || back.String() != tr.String() {
+		t.Errorf("Trace JSON round trip failed: %v", err)
+	}
+}
+
+func TestOSM15_Vectors(t *testing.T) {
+	raw, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatalf("Read vectors: %v", err)
+	}
+	var corpus VectorFile
+	if err := json.Unmarshal(raw, &corpus); err != nil {
+		t.Fatalf("Parse vectors: %v", err)
+	}
+	if corpus.Version != VectorsVersion {
+		t.Fatalf("Vector corpus version %d, library expects %d", corpus.Version, VectorsVersion)
+	}
+	if len(corpus.Vectors) == 0 {
+		t.Fatal("Vector corpus is empty")
+	}
+
+	for _, v := range corpus.Vectors {
+		if err := v.Check(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A tampered expectation must be caught
+	v := corpus.Vectors[0]
+	tampered := *v.Expected
+	tampered.Digest = strings.Repeat("0", 64)
+	v.Expected = &tampered
+	if err := v.Check(); err == nil {
+		t.Error("Check accepted a wrong digest")
+	}
+
+	// So must a wrong nested struct hash, even with the digest intact
+	for _, v := range corpus.Vectors {
+		if v.Name != "mail-nested-structs" {
+			continue
+		}
+		tampered := *v.Expected
+		tampered.StructHashes = map[string]string{}
+		for path, hash := range v.Expected.StructHashes {
+			tampered.StructHashes[path] = hash
+		}
+		tampered.StructHashes["message.from.wallet"] = strings.Repeat("0", 64)
+		v.Expected = &tampered
+		if err := v.Check(); err == nil || !strings.Contains(err.Error(), "message.from.wallet") {
+			t.Errorf("Wrong nested struct hash: %v", err)
+		}
+	}
+}
+
+func TestOSM15_Signers(t *testing.T) {
+	ctx := context.Background()
+	data := TypedData{
+		Domain:      TypedDomain{Name: "Signers", Version: "1", ChainID: 1},
+		Types:       map[string][]TypedMember{"Msg": {{Name: "text", Type: "string"}}},
+		PrimaryType: "Msg",
+		Message:     map[string]interface{}{"text": "hello"},
+	}
+	privB64, pubB64, _ := GenerateKeypair()
+	seed, _ := base64.StdEncoding.DecodeString(privB64)
+
+	// 1. In-memory, keystore-backed and crypto.Signer adapters agree
+	mem, err := NewKeySigner(seed)
+	if err != nil {
+		t.Fatalf("NewKeySigner error: %v", err)
+	}
+	keystoreJSON, _ := EncryptKey(privB64, "pw")
+	ks, err := NewKeystoreSigner(keystoreJSON, "pw")
+	if err != nil {
+		t.Fatalf("NewKeystoreSigner error: %v", err)
+	}
+	adapted, err := NewCryptoSigner(ed25519.NewKeyFromSeed(seed))
+	if err != nil {
+		t.Fatalf("NewCryptoSigner error: %v", err)
+	}
+	for i, s := range []Signer{mem, ks, adapted} {
+		sig, err := SignTypedDataWith(ctx, data, s)
+		if err != nil {
+			t.Fatalf("Signer %d error: %v", i, err)
+		}
+		if valid, _ := VerifyTypedData(data, sig, pubB64); !valid {
+			t.Errorf("Signer %d produced an invalid signature", i)
+		}
+	}
+
+	// 2. Bad key material is rejected instead of signing with a garbage seed
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := SignTypedData(data, bad); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("SignTypedData(%q): expected ErrInvalidKey, got %v", bad, err)
+		}
+	}
+	if _, err := NewKeystoreSigner(keystoreJSON, "wrong"); err == nil {
+		t.Error("Keystore signer accepted a wrong password")
+	}
+
+	// 3. Cancelled contexts and misbehaving signers fail
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := SignTypedDataWith(cancelled, data, mem); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := SignTypedDataWith(ctx, data, badSigner{mem}); err == nil {
+		t.Error("Accepted a signature that does not verify")
+	}
+}
+
+// badSigner returns signatures over the wrong digest.
+type badSigner struct{ Signer }
+
+func (b badSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return b.Signer.SignDigest(ctx, append([]byte{0}, digest...))
+}
+
+func TestOSM15_Policy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{
+		"domains": [{"name": "Octra", "chainIds": [1, 2]}],
+		"types": {
+			"Batch": {
+				"fields": {
+					"orders[].amount": {"max": "1000"},
+					"orders[].to": {"allowed": ["oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "octFJKTv1un7qsnyKdwKez7B67JJp3oCU5ntCVXcRsWEjtg"]}
+				}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("ParsePolicy error: %v", err)
+	}
+	newData := func() TypedData {
+		return TypedData{
+			Domain: TypedDomain{Name: "Octra", Version: "1", ChainID: 1},
+			Types: map[string][]TypedMember{
+				"Batch": {{Name: "orders", Type: "Order[]"}},
+				"Order": {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}},
+			},
+			PrimaryType: "Batch",
+			Message: map[string]interface{}{"orders": []interface{}{
+				map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "amount": "999"},
+				map[string]interface{}{"to": "octFJKTv1un7qsnyKdwKez7B67JJp3oCU5ntCVXcRsWEjtg", "amount": json.Number("1000")},
+			}},
+		}
+	}
+
+	// 1. Allowed data passes
+	if err := policy.Check(newData()); err != nil {
+		t.Fatalf("Allowed data rejected: %v", err)
+	}
+
+	// 2. Each constraint rejects with a path
+	cases := []struct {
+		name   string
+		mutate func(d *TypedData)
+		path   string
+	}{
+		{"chainId", func(d *TypedData) { d.Domain.ChainID = 3 }, "domain"},
+		{"domain name", func(d *TypedData) { d.Domain.Name = "Other" }, "domain"},
+		{"salt", func(d *TypedData) { d.Domain.Salt = "0x" + strings.Repeat("ab", 32) }, "domain"},
+		{"extension", func(d *TypedData) {
+			d.Domain.Extensions = []DomainField{{Name: "region", Type: "string", Value: "eu"}}
+		}, "domain"},
+		{"primaryType", func(d *TypedData) {
+			d.Types["Other"] = d.Types["Batch"]
+			d.PrimaryType = "Other"
+		}, "primaryType"},
+		{"amount", func(d *TypedData) {
+			d.Message["orders"].([]interface{})[1].(map[string]interface{})["amount"] = "1001"
+		}, "message.orders[1].amount"},
+		{"recipient", func(d *TypedData) {
+			d.Message["orders"].([]interface{})[0].(map[string]interface{})["to"] = "oct6FbDRScGruVdATaNWzD51xJkTfYCVwxSZDb7gzqCLzwf"
+		}, "message.orders[0].to"},
+	}
+	for _, c := range cases {
+		data := newData()
+		c.mutate(&data)
+		err := policy.Check(data)
+		var pe *PolicyError
+		if !errors.As(err, &pe) || !errors.Is(err, ErrPolicyViolation) || pe.Path != c.path {
+			t.Errorf("%s: got %v, want a violation at %s", c.name, err, c.path)
+		}
+	}
+
+	// 3. Malformed data is rejected before the policy is consulted
+	data := newData()
+	data.Message["extra"] = 1
+	if err := policy.Check(data); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Undeclared field: got %v", err)
+	}
+
+	// 4. Salts and extensions pass only when a rule covers them
+	salted, err := ParsePolicy([]byte(`{
+		"domains": [{"name": "Octra", "salts": ["0xABAB` + strings.Repeat("ab", 30) + `"], "extensions": {"region": {"allowed": ["eu"]}}}],
+		"types": {"Batch": {}}
+	}`))
+	if err != nil {
+		t.Fatalf("ParsePolicy error: %v", err)
+	}
+	data = newData()
+	data.Domain.Salt = "0x" + strings.Repeat("ab", 32)
+	data.Domain.Extensions = []DomainField{{Name: "region", Type: "string", Value: "eu"}}
+	if err := salted.Check(data); err != nil {
+		t.Errorf("Covered salt and extension rejected: %v", err)
+	}
+	data.Domain.Extensions[0].Value = "us"
+	if err := salted.Check(data); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("Disallowed extension value: got %v", err)
+	}
+
+	// 5. Bad policies are refused
+	for _, bad := range []string{
+		`{"domains": [{"name": "Octra", "extensions": {"region": {"max": "ten"}}}], "types": {"Batch": {}}}`,
+		`{"domains": [{"name": "Octra"}], "types": {"Batch": {"fields": {"amount": {"max": "ten"}}}}}`,
+		`{"domains": [{"name": "Octra"}], "types": {"Batch": {"fields": {"amount": {"maximum": "10"}}}}}`,
+		`{"types": {"Batch": {}}}`,
+	} {
+		if _, err := ParsePolicy([]byte(bad)); err == nil {
+			t.Errorf("ParsePolicy accepted %s", bad)
+		}
+	}
+}
+
+func TestOSM15_ReplayProtection(t *testing.T) {
+	// Stores prune by the wall clock, so stay close to it.
+	now := time.Now().Truncate(time.Second)
+	privB64, pubB64, _ := GenerateKeypair()
+	sign := func(nonce interface{}, validAfter, deadline int64) SignedPayload {
+		data := TypedData{
+			Domain: TypedDomain{Name: "Pay", Version: "1", ChainID: 1},
+			Types: map[string][]TypedMember{"Pay": {
+				{Name: "amount", Type: "uint256"},
+				{Name: "nonce", Type: "uint256"},
+				{Name: "validAfter", Type: "uint64"},
+				{Name: "deadline", Type: "uint64"},
+			}},
+			PrimaryType: "Pay",
+			Message:     map[string]interface{}{"amount": 1, "nonce": nonce, "validAfter": validAfter, "deadline": deadline},
+		}
+		sig, err := SignTypedData(data, privB64)
+		if err != nil {
+			t.Fatalf("SignTypedData error: %v", err)
+		}
+		return SignedPayload{Data: data, Signature: sig}
+	}
+	window := func(nonce interface{}) SignedPayload {
+		return sign(nonce, now.Unix()-60, now.Unix()+60)
+	}
+
+	path := filepath.Join(t.TempDir(), "nonces")
+	fileStore, err := OpenFileNonceStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileNonceStore error: %v", err)
+	}
+	for name, store := range map[string]NonceStore{"memory": &MemoryNonceStore{}, "file": fileStore} {
+		v := &Verifier{Store: store, RequireNonce: true, Now: func() time.Time { return now }}
+
+		// 1. A fresh payload is accepted once, whatever the nonce spelling
+		if err := v.Verify(window(7), pubB64); err != nil {
+			t.Fatalf("%s: fresh payload rejected: %v", name, err)
+		}
+		if err := v.Verify(window(7), pubB64); !errors.Is(err, ErrReplay) {
+			t.Errorf("%s: replay error = %v", name, err)
+		}
+		if err := v.Verify(window("0x07"), pubB64); !errors.Is(err, ErrReplay) {
+			t.Errorf("%s: respelt nonce error = %v", name, err)
+		}
+
+		// 2. The validity window is enforced before the nonce is spent
+		if err := v.Verify(sign(8, now.Unix()-120, now.Unix()-1), pubB64); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s: expired error = %v", name, err)
+		}
+		if err := v.Verify(sign(8, now.Unix(), now.Unix()+60), pubB64); !errors.Is(err, ErrNotYetValid) {
+			t.Errorf("%s: early error = %v", name, err)
+		}
+		if err := v.Verify(window(8), pubB64); err != nil {
+			t.Errorf("%s: nonce spent by a rejected payload: %v", name, err)
+		}
+
+		// 3. Bad signatures do not spend nonces
+		forged := window(9)
+		forged.Data.Message["amount"] = 2
+		if err := v.Verify(forged, pubB64); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: forged error = %v", name, err)
+		}
+		if err := v.Verify(window(9), pubB64); err != nil {
+			t.Errorf("%s: nonce spent by a forged payload: %v", name, err)
+		}
+	}
+
+	// 4. The file store remembers nonces across restarts, and only one
+	// process may have it open
+	if _, err := OpenFileNonceStore(path); !errors.Is(err, filelock.ErrLocked) {
+		t.Errorf("Second open error = %v", err)
+	}
+	fileStore.Close()
+	reopened, err := OpenFileNonceStore(path)
+	if err != nil {
+		t.Fatalf("Reopen error: %v", err)
+	}
+	defer reopened.Close()
+	v := &Verifier{Store: reopened, Now: func() time.Time { return now }}
+	if err := v.Verify(window(7), pubB64); !errors.Is(err, ErrReplay) {
+		t.Errorf("Replay after restart error = %v", err)
+	}
+	if err := v.Verify(window(10), ""); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("Verify without a key error = %v", err)
+	}
+	p := window(11)
+	signed := NewSignedPayload(p.Data, p.Signature, decodePublicKey(pubB64))
+	if addr, err := v.VerifySigned(signed); err != nil || addr != signed.Address {
+		t.Errorf("VerifySigned = %s, %v", addr, err)
+	}
+	if _, err := v.VerifySigned(signed); !errors.Is(err, ErrReplay) {
+		t.Errorf("VerifySigned replay error = %v", err)
+	}
+	if _, err := v.VerifySigned(window(12)); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("VerifySigned of a version 1 payload error = %v", err)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*tmp*")); len(tmps) != 0 {
+		t.Errorf("Temporary files left behind: %v", tmps)
+	}
+
+	// 5. A nonce whose write fails is not spent
+	broken, err := OpenFileNonceStore(filepath.Join(t.TempDir(), "broken"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken.file.Close()
+	if err := broken.Use("k", time.Time{}); err == nil {
+		t.Error("Use succeeded without a file")
+	}
+	if broken.mem.seen("k") {
+		t.Error("Failed write still spent the nonce")
+	}
+	broken.Close()
+
+	// 6. Missing fields are rejected when required
+	plain := TypedData{
+		Domain:      TypedDomain{Name: "Pay", Version: "1", ChainID: 1},
+		Types:       map[string][]TypedMember{"Msg": {{Name: "text", Type: "string"}}},
+		PrimaryType: "Msg",
+		Message:     map[string]interface{}{"text": "hi"},
+	}
+	sig, _ := SignTypedData(plain, privB64)
+	if err := (&Verifier{RequireDeadline: true}).Verify(SignedPayload{Data: plain, Signature: sig}, pubB64); !errors.Is(err, ErrNoDeadline) {
+		t.Errorf("Missing deadline error = %v", err)
+	}
+	if err := (&Verifier{RequireNonce: true}).Verify(SignedPayload{Data: plain, Signature: sig}, pubB64); !errors.Is(err, ErrNoNonce) {
+		t.Errorf("Missing nonce error = %v", err)
+	}
+}
+
+func TestOSM15_MultiSignature(t *testing.T) {
+	ctx := context.Background()
+	data := TypedData{
+		Domain:      TypedDomain{Name: "Treasury", Version: "1", ChainID: 1},
+		Types:       map[string][]TypedMember{"Withdraw": {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}}},
+		PrimaryType: "Withdraw",
+		Message:     map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "amount": "5000"},
+	}
+	var keys []string
+	var signers []Signer
+	for i := 0; i < 4; i++ {
+		priv, pub, _ := GenerateKeypair()
+		s, _ := NewKeySignerFromBase64(priv)
+		keys = append(keys, pub)
+		signers = append(signers, s)
+	}
+	allowed := keys[:3]
+
+	// 1. Signatures are added incrementally and checked on the way in
+	payload := MultiSignedPayload{Data: data}
+	if err := payload.Sign(ctx, signers[0]); err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if err := payload.Sign(ctx, signers[0]); err == nil {
+		t.Error("Same key signed twice")
+	}
+	// the duplicate is refused before signing, not by the signature check
+	if err := payload.Sign(ctx, badSigner{signers[0]}); err == nil || errors.Is(err, ErrBadSignature) {
+		t.Errorf("Duplicate signer error = %v", err)
+	}
+	if !payload.HasSigned(signers[0].Public()) || payload.HasSigned(signers[1].Public()) {
+		t.Error("HasSigned does not match the signatures")
+	}
+	if err := payload.AddSignature(keys[1], payload.Signatures[0].Signature); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Mismatched signature error = %v", err)
+	}
+	if err := payload.AddSignature("AAAA", payload.Signatures[0].Signature); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Short key error = %v", err)
+	}
+
+	// 2. Outsiders and bad signatures do not count
+	payload.Sign(ctx, signers[3])
+	res, err := VerifyThreshold(payload, allowed, 2)
+	if !errors.Is(err, ErrThresholdNotMet) || res.Valid != 1 || res.Met {
+		t.Fatalf("1 of 2: %+v, %v", res, err)
+	}
+	if !res.Signers[1].Valid || res.Signers[1].Allowed {
+		t.Errorf("Outsider result = %+v", res.Signers[1])
+	}
+
+	// 3. M-of-N is met once enough allowed keys sign
+	payload.Sign(ctx, signers[2])
+	res, err = VerifyThreshold(payload, allowed, 2)
+	if err != nil || !res.Met || res.Valid != 2 {
+		t.Fatalf("2 of 2: %+v, %v", res, err)
+	}
+
+	// 4. Tampering invalidates every signature; duplicates count once
+	tampered := payload
+	tampered.Signatures = append(append([]MultiSignature(nil), payload.Signatures...), payload.Signatures[0])
+	if res, _ := VerifyThreshold(tampered, allowed, 3); res.Valid != 2 || res.Signers[3].Reason != "duplicate signature" {
+		t.Errorf("Duplicate result = %+v", res)
+	}
+	tampered.Data.Message = map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "amount": "5001"}
+	if res, _ := VerifyThreshold(tampered, allowed, 1); res.Valid != 0 {
+		t.Errorf("Tampered data still has %d valid signatures", res.Valid)
+	}
+	if _, err := VerifyThreshold(payload, allowed, 4); err == nil || errors.Is(err, ErrThresholdNotMet) {
+		t.Errorf("Impossible threshold error = %v", err)
+	}
+
+	// 5. Keys are compared decoded: another base64 spelling of the same key
+	// (different unused trailing bits) is the same signer
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	respell := func(k string) string {
+		c := strings.IndexByte(alphabet, k[42])
+		return k[:42] + string(alphabet[c^1]) + k[43:]
+	}
+	alias := payload.Signatures[0]
+	alias.PublicKey = respell(alias.PublicKey)
+	if alias.PublicKey == payload.Signatures[0].PublicKey || publicKeyAddress(alias.PublicKey) == "" {
+		t.Fatal("respell did not produce another spelling of the key")
+	}
+	if err := payload.AddSignature(alias.PublicKey, alias.Signature); err == nil {
+		t.Error("Same key signed twice under another spelling")
+	}
+	if !(&MultiSignedPayload{Signatures: []MultiSignature{alias}}).HasSigned(signers[0].Public()) {
+		t.Error("HasSigned missed another spelling of the key")
+	}
+	aliased := payload
+	aliased.Signatures = append(append([]MultiSignature(nil), payload.Signatures...), alias)
+	if res, _ := VerifyThreshold(aliased, allowed, 3); res.Valid != 2 || res.Signers[3].Reason != "duplicate signature" {
+		t.Errorf("Respelled duplicate result = %+v", res)
+	}
+	if res, err := VerifyThreshold(payload, []string{respell(keys[0]), keys[2], keys[0]}, 2); err != nil || res.Valid != 2 {
+		t.Errorf("Respelled allowed keys: %+v, %v", res, err)
+	}
+	if _, err := VerifyThreshold(payload, []string{keys[0], "AAAA"}, 1); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Malformed allowed key error = %v", err)
+	}
+}
+
+func TestOSM15_SignedPayloadV2(t *testing.T) {
+	data := TypedData{
+		Domain:      TypedDomain{Name: "Payload", Version: "1", ChainID: 1},
+		Types:       map[string][]TypedMember{"Msg": {{Name: "text", Type: "string"}}},
+		PrimaryType: "Msg",
+		Message:     map[string]interface{}{"text": "hello"},
+	}
+	privB64, pubB64, _ := GenerateKeypair()
+	otherPriv, otherPub, _ := GenerateKeypair()
+	signer, _ := NewKeySignerFromBase64(privB64)
+	sig, _ := SignTypedData(data, privB64)
+
+	// 1. Version 2 payloads name and verify their signer
+	v2, err := ExportSignedPayload(data, sig, signer.Public())
+	if err != nil {
+		t.Fatalf("ExportSignedPayload error: %v", err)
+	}
+	payload, err := ParseSignedPayload(v2)
+	if err != nil || payload.Version != 2 || payload.PublicKey != pubB64 {
+		t.Fatalf("ParseSignedPayload = %+v, %v", payload, err)
+	}
+	if addr, err := payload.Verify(); err != nil || addr != PublicKeyToAddress(signer.Public()) {
+		t.Errorf("Verify = %s, %v", addr, err)
+	}
+	if valid, err := VerifyFromJSON(v2, pubB64); !valid || err != nil {
+		t.Errorf("VerifyFromJSON = %v, %v", valid, err)
+	}
+	if valid, err := VerifyFromJSON(v2, ""); valid || !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("VerifyFromJSON trusted the embedded key: %v, %v", valid, err)
+	}
+	if valid, _ := VerifyFromJSON(v2, otherPub); valid {
+		t.Error("Payload verified against a different key")
+	}
+
+	// 2. Inconsistent signer fields are rejected
+	forged := payload
+	forged.PublicKey = otherPub
+	forgedJSON, _ := json.Marshal(forged)
+	if _, err := ParseSignedPayload(forgedJSON); err == nil {
+		t.Error("Address/key mismatch accepted")
+	}
+	otherSig, _ := SignTypedData(data, otherPriv)
+	swapped := NewSignedPayload(data, sig, signer.Public())
+	swapped.Signature = otherSig
+	if _, err := swapped.Verify(); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Swapped signature error = %v", err)
+	}
+	for _, bad := range []string{
+		`{"version": 3, "data": {}, "signature": ""}`,
+		`{"version": 2, "algorithm": "secp256k1", "data": {}, "signature": ""}`,
+	} {
+		if _, err := ParseSignedPayload([]byte(bad)); err == nil {
+			t.Errorf("ParseSignedPayload accepted %s", bad)
+		}
+	}
+
+	// 3. Version 1 payloads still parse and verify with a key
+	v1, _ := ExportToJSON(data, sig)
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(v1, &fields); len(fields) != 2 {
+		t.Errorf("ExportToJSON output changed: %s", v1)
+	}
+	legacy, err := ParseSignedPayload(v1)
+	if err != nil || legacy.Version != 1 {
+		t.Fatalf("Legacy parse = %+v, %v", legacy, err)
+	}
+	if valid, err := VerifyFromJSON(v1, pubB64); !valid || err != nil {
+		t.Errorf("Legacy VerifyFromJSON = %v, %v", valid, err)
+	}
+	if _, err := VerifyFromJSON(v1, ""); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("Legacy without key error = %v", err)
+	}
+
+	// 4. Malformed keys are errors, not panics
+	if _, err := VerifyTypedData(data, sig, "AAAA"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Short key error = %v", err)
+	}
+	if valid, err := VerifyTypedData(data, "not base64!", pubB64); valid || err != nil {
+		t.Errorf("Malformed signature = %v, %v", valid, err)
+	}
+}
+
+func TestOSM15_VerifyBatch(t *testing.T) {
+	ctx := context.Background()
+	privB64, pubB64, _ := GenerateKeypair()
+	var items []BatchItem
+	for i := 0; i < 50; i++ {
+		data := benchOrderData()
+		data.Message["owner"] = PublicKeyToAddress([]byte(fmt.Sprint(i)))
+		sig, err := SignTypedData(data, privB64)
+		if err != nil {
+			t.Fatalf("SignTypedData error: %v", err)
+		}
+		items = append(items, BatchItem{ID: fmt.Sprint(i), Data: data, Signature: sig, PublicKey: pubB64})
+	}
+	items[3].Signature = items[4].Signature
+	items[7].PublicKey = "AAAA"
+	items[9].Data.PrimaryType = "Missing"
+
+	for _, cofactored := range []bool{false, true} {
+		report, err := VerifyBatch(ctx, items, BatchOptions{Workers: 4, Cofactored: cofactored})
+		if err != nil {
+			t.Fatalf("VerifyBatch error: %v", err)
+		}
+		if report.Total != 50 || report.Valid != 47 || report.Invalid != 1 || report.Errors != 2 {
+			t.Errorf("Cofactored %v: aggregate = %d/%d/%d/%d", cofactored, report.Total, report.Valid, report.Invalid, report.Errors)
+		}
+		for i, r := range report.Results {
+			want := i != 3 && i != 7 && i != 9
+			if r.ID != fmt.Sprint(i) || r.Valid != want {
+				t.Errorf("Cofactored %v: result %d = %+v", cofactored, i, r)
+			}
+		}
+		if report.Results[7].Error == "" || report.Results[9].Error == "" || report.Results[3].Error != "" {
+			t.Error("Errors and invalid signatures are not told apart")
+		}
+	}
+
+	// The batch equation accepts a run of good signatures from several keys
+	// and rejects it as soon as one is tampered with
+	var run []batchSignature
+	for i := 0; i < 8; i++ {
+		priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i + 1)}, 32))
+		msg := []byte(fmt.Sprint("message ", i))
+		if i == 5 {
+			msg = []byte("tampered")
+		}
+		bs, ok := parseBatchSignature(priv.Public().(ed25519.PublicKey), msg, ed25519.Sign(priv, []byte(fmt.Sprint("message ", i))))
+		if !ok {
+			t.Fatalf("parseBatchSignature rejected signature %d", i)
+		}
+		run = append(run, bs)
+	}
+	if verifyEd25519Batch(run) {
+		t.Error("Batch with a tampered message accepted")
+	}
+	if !verifyEd25519Batch(append(run[:5:5], run[6:]...)) {
+		t.Error("Batch of valid signatures rejected")
+	}
+
+	// A signature from small-order points (order-2 key, identity R, S = 0)
+	// gets one result per mode, alone or next to good and bad items: only
+	// the cofactored equation accepts it
+	orderTwo := append([]byte{0xec}, bytes.Repeat([]byte{0xff}, 30)...)
+	orderTwo = append(orderTwo, 0x7f)
+	identitySig := append([]byte{1}, make([]byte, 63)...)
+	small := BatchItem{ID: "small", PublicKey: base64.StdEncoding.EncodeToString(orderTwo), Signature: base64.StdEncoding.EncodeToString(identitySig)}
+	for n := 0; ; n++ {
+		small.Data = benchOrderData()
+		small.Data.Message["owner"] = PublicKeyToAddress([]byte(fmt.Sprint(n)))
+		digest, _ := HashTypedData(small.Data)
+		// An odd k leaves k*A = A, so ed25519.Verify rejects.
+		if bs, ok := parseBatchSignature(orderTwo, digest, identitySig); ok && bs.k.Bytes()[0]&1 == 1 {
+			break
+		}
+	}
+	if valid, _ := VerifyTypedData(small.Data, small.Signature, small.PublicKey); valid {
+		t.Fatal("ed25519.Verify accepted the small-order signature")
+	}
+	for _, cofactored := range []bool{false, true} {
+		for name, batch := range map[string][]BatchItem{
+			"alone":     {small},
+			"with good": append([]BatchItem{small}, items[10:20]...),
+			"with bad":  append([]BatchItem{small}, items[:10]...),
+		} {
+			report, err := VerifyBatch(ctx, batch, BatchOptions{Workers: 1, Cofactored: cofactored})
+			if err != nil || report.Results[0].Valid != cofactored {
+				t.Errorf("Cofactored %v, %s: small-order result = %+v, %v", cofactored, name, report.Results[0], err)
+			}
+		}
+	}
+
+	clean, err := VerifyBatch(ctx, append(items[:3:3], items[10:]...), BatchOptions{Workers: 2, Cofactored: true})
+	if err != nil || clean.Valid != clean.Total {
+		t.Errorf("Clean batch = %+v, %v", clean, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := VerifyBatch(cancelled, items, BatchOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled batch error = %v", err)
+	}
+}
+
+func BenchmarkVerifyBatch(b *testing.B) {
+	privB64, pubB64, _ := GenerateKeypair()
+	data := benchOrderData()
+	sig, _ := SignTypedData(data, privB64)
+	items := make([]BatchItem, 256)
+	for i := range items {
+		items[i] = BatchItem{Data: data, Signature: sig, PublicKey: pubB64}
+	}
+	registry := NewRegistry()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		VerifyBatch(context.Background(), items, BatchOptions{Registry: registry, Cofactored: true})
+	}
+}
+
+// legacyKeystoreV1 was written by the scrypt-only EncryptKey of 0.2.x for
+// the seed 0x00..0x1f with password "legacy-pass".
+const legacyKeystoreV1 = `{
+  "address": "oct6ooAjytx2tERAi6rpXCqxMKCBr4z6Kw3UoRDbuuAUiGT",
+  "crypto": {
+    "cipher": "aes-256-gcm",
+    "ciphertext": "WKLZTBVqKXBopFCMIN3ZaWZR37HOJgOR3TsLoN+S2bCqNF+/9eN4TuIbSTQv7w9e",
+    "cipherparams": {
+      "iv": "s196kWN+HYvJtkFZ"
+    },
+    "kdf": "scrypt",
+    "kdfparams": {
+      "n": 32768,
+      "r": 8,
+      "p": 1,
+      "salt": "QEBOIhuu4OvMajfv4JS3DTYLagxh4BXzfoV6m7GSH/M="
+    }
+  }
+}`
+
+func TestOSM15_KeystoreV2(t *testing.T) {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	privB64 := base64.StdEncoding.EncodeToString(seed)
+
+	// 1. Version 1 scrypt files still decrypt
+	got, err := DecryptKey([]byte(legacyKeystoreV1), "legacy-pass")
+	if err != nil || got != privB64 {
+		t.Fatalf("Legacy DecryptKey = %q, %v", got, err)
+	}
+	if _, err := DecryptKey([]byte(legacyKeystoreV1), "wrong"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Legacy wrong password error = %v", err)
+	}
+
+	// 2. New keystores use the chosen KDF
+	for _, kdf := range []string{"", KDFScrypt} {
+		ksJSON, err := EncryptKeyWithOptions(privB64, "pw", KeystoreOptions{KDF: kdf})
+		if err != nil {
+			t.Fatalf("EncryptKeyWithOptions(%q) error: %v", kdf, err)
+		}
+		var ks Keystore
+		json.Unmarshal(ksJSON, &ks)
+		want := kdf
+		if want == "" {
+			want = KDFArgon2id
+		}
+		if ks.Version != KeystoreV3 || ks.Crypto.Kdf != want {
+			t.Errorf("Keystore header = v%d %s, want v3 %s", ks.Version, ks.Crypto.Kdf, want)
+		}
+		if got, err := DecryptKey(ksJSON, "pw"); err != nil || got != privB64 {
+			t.Errorf("%s round trip = %q, %v", want, got, err)
+		}
+	}
+	if _, err := EncryptKeyWithOptions(privB64, "pw", KeystoreOptions{KDF: "pbkdf2"}); !errors.Is(err, ErrInvalidKeystore) {
+		t.Errorf("Unknown KDF error = %v", err)
+	}
+	if _, err := EncryptKeyWithOptions(privB64, "pw", KeystoreOptions{Profile: 7}); !errors.Is(err, ErrInvalidKeystore) {
+		t.Errorf("Unknown profile error = %v", err)
+	}
+	if _, err := EncryptKey("not-a-key", "pw"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Malformed seed error = %v", err)
+	}
+
+	// 3. Parameters outside the bounds are refused before deriving
+	argonJSON, _ := EncryptKey(privB64, "pw")
+	tamper := func(src []byte, edit func(*Keystore)) []byte {
+		var ks Keystore
+		json.Unmarshal(src, &ks)
+		edit(&ks)
+		out, _ := json.Marshal(ks)
+		return out
+	}
+	bad := map[string][]byte{
+		"scrypt n too small":  tamper([]byte(legacyKeystoreV1), func(ks *Keystore) { ks.Crypto.KdfParams.N = 2 }),
+		"scrypt n too large":  tamper([]byte(legacyKeystoreV1), func(ks *Keystore) { ks.Crypto.KdfParams.N = 1 << 30 }),
+		"scrypt n not pow2":   tamper([]byte(legacyKeystoreV1), func(ks *Keystore) { ks.Crypto.KdfParams.N = 30000 }),
+		"scrypt r too large":  tamper([]byte(legacyKeystoreV1), func(ks *Keystore) { ks.Crypto.KdfParams.R = 1024 }),
+		"short salt":          tamper([]byte(legacyKeystoreV1), func(ks *Keystore) { ks.Crypto.KdfParams.Salt = "AAAA" }),
+		"short iv":            tamper([]byte(legacyKeystoreV1), func(ks *Keystore) { ks.Crypto.CipherParams.IV = "AAAA" }),
+		"argon2 memory small": tamper(argonJSON, func(ks *Keystore) { ks.Crypto.KdfParams.Memory = 8 }),
+		"argon2 memory large": tamper(argonJSON, func(ks *Keystore) { ks.Crypto.KdfParams.Memory = 1 << 31 }),
+		"argon2 time large":   tamper(argonJSON, func(ks *Keystore) { ks.Crypto.KdfParams.Time = 1000 }),
+		"argon2 threads zero": tamper(argonJSON, func(ks *Keystore) { ks.Crypto.KdfParams.P = 0 }),
+		"v1 with argon2":      tamper(argonJSON, func(ks *Keystore) { ks.Version = 0 }),
+		"unknown version":     tamper(argonJSON, func(ks *Keystore) { ks.Version = 9 }),
+		"unknown cipher":      tamper(argonJSON, func(ks *Keystore) { ks.Crypto.Cipher = "aes-128-ctr" }),
+	}
+	for name, ksJSON := range bad {
+		if _, err := DecryptKey(ksJSON, "pw"); !errors.Is(err, ErrInvalidKeystore) {
+			t.Errorf("%s: DecryptKey error = %v, want ErrInvalidKeystore", name, err)
+		}
+	}
+}
+
+func TestOSM15_KeystoreV3(t *testing.T) {
+	privB64, _, _ := GenerateKeypair()
+	_, otherPub, _ := GenerateKeypair()
+	otherPubBytes, _ := base64.StdEncoding.DecodeString(otherPub)
+	otherAddr := PublicKeyToAddress(otherPubBytes)
+
+	edit := func(src []byte, f func(map[string]interface{})) []byte {
+		var m map[string]interface{}
+		json.Unmarshal(src, &m)
+		f(m)
+		out, _ := json.Marshal(m)
+		return out
+	}
+	params := func(m map[string]interface{}) map[string]interface{} {
+		return m["crypto"].(map[string]interface{})["kdfparams"].(map[string]interface{})
+	}
+
+	// 1. Every header field of a version 3 keystore is authenticated
+	ksJSON, _ := EncryptKey(privB64, "pw")
+	tampered := map[string][]byte{
+		"address":   edit(ksJSON, func(m map[string]interface{}) { m["address"] = otherAddr }),
+		"kdf time":  edit(ksJSON, func(m map[string]interface{}) { params(m)["t"] = 3 }),
+		"downgrade": edit(ksJSON, func(m map[string]interface{}) { m["version"] = 2 }),
+	}
+	for name, data := range tampered {
+		if _, err := DecryptKey(data, "pw"); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("Edited %s: DecryptKey error = %v", name, err)
+		}
+	}
+
+	// 2. Older files have their address checked against the key
+	swapped := edit([]byte(legacyKeystoreV1), func(m map[string]interface{}) { m["address"] = otherAddr })
+	if _, err := DecryptKey(swapped, "legacy-pass"); !errors.Is(err, ErrAddressMismatch) {
+		t.Errorf("Swapped v1 address error = %v", err)
+	}
+
+	// 3. Migration keeps the key and KDF cost
+	if _, err := MigrateKeystore([]byte(legacyKeystoreV1), "wrong"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Migrate wrong password error = %v", err)
+	}
+	migrated, err := MigrateKeystore([]byte(legacyKeystoreV1), "legacy-pass")
+	if err != nil {
+		t.Fatalf("MigrateKeystore error: %v", err)
+	}
+	var ks Keystore
+	json.Unmarshal(migrated, &ks)
+	if ks.Version != KeystoreV3 || ks.Crypto.Kdf != KDFScrypt || ks.Crypto.KdfParams.N != 32768 {
+		t.Errorf("Migrated header = %+v", ks)
+	}
+	original, _ := DecryptKey([]byte(legacyKeystoreV1), "legacy-pass")
+	if got, err := DecryptKey(migrated, "legacy-pass"); err != nil || got != original {
+		t.Errorf("Migrated keystore decrypts to %q, %v", got, err)
+	}
+}
+
+func TestOSM15_ReencryptKey(t *testing.T) {
+	privB64, _, _ := GenerateKeypair()
+	ksJSON, _ := EncryptKeyWithOptions(privB64, "old", KeystoreOptions{KDF: KDFScrypt})
+
+	if _, err := ReencryptKey(ksJSON, "wrong", "new", ReencryptOptions{KeepKDF: true}); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Wrong old password error = %v", err)
+	}
+
+	// 1. KeepKDF keeps the KDF and its cost
+	rotated, err := ReencryptKey(ksJSON, "old", "new", ReencryptOptions{KeepKDF: true})
+	if err != nil {
+		t.Fatalf("ReencryptKey error: %v", err)
+	}
+	var ks Keystore
+	json.Unmarshal(rotated, &ks)
+	if ks.Crypto.Kdf != KDFScrypt || ks.Crypto.KdfParams.N != 1<<15 {
+		t.Errorf("Rotated KDF = %s %+v", ks.Crypto.Kdf, ks.Crypto.KdfParams)
+	}
+	if _, err := DecryptKey(rotated, "old"); !errors.Is(err, ErrInvalidPassword) {
+		t.Error("Old password still opens the rotated keystore")
+	}
+	if got, err := DecryptKey(rotated, "new"); err != nil || got != privB64 {
+		t.Errorf("Rotated keystore decrypts to %q, %v", got, err)
+	}
+
+	// 2. Options upgrade the KDF
+	upgraded, err := ReencryptKey(rotated, "new", "new", ReencryptOptions{KDF: KeystoreOptions{Profile: ProfileModerate}})
+	if err != nil {
+		t.Fatalf("ReencryptKey upgrade error: %v", err)
+	}
+	ks = Keystore{}
+	json.Unmarshal(upgraded, &ks)
+	if ks.Crypto.Kdf != KDFArgon2id || ks.Crypto.KdfParams.Memory != 256*1024 {
+		t.Errorf("Upgraded KDF = %s %+v", ks.Crypto.Kdf, ks.Crypto.KdfParams)
+	}
+	if got, _ := DecryptKey(upgraded, "new"); got != privB64 {
+		t.Error("Upgraded keystore holds a different key")
+	}
+}
+
+func TestOSM15_Mnemonic(t *testing.T) {
+	// 1. BIP-39 and SLIP-0010 reference vectors
+	zero := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	if got := entropyToMnemonic(make([]byte, 16)); got != zero {
+		t.Errorf("Zero entropy mnemonic = %q", got)
+	}
+	legal := "legal winner thank year wave sausage worth useful legal winner thank yellow"
+	if got := entropyToMnemonic(bytes.Repeat([]byte{0x7f}, 16)); got != legal {
+		t.Errorf("0x7f entropy mnemonic = %q", got)
+	}
+	seed, err := MnemonicToSeed(zero, "TREZOR")
+	want := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if err != nil || hex.EncodeToString(seed) != want {
+		t.Errorf("MnemonicToSeed = %x, %v", seed, err)
+	}
+	slipSeed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	key, chainCode := slip10Master(slipSeed)
+	if hex.EncodeToString(key) != "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7" ||
+		hex.EncodeToString(chainCode) != "90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb" {
+		t.Errorf("SLIP-0010 master = %x %x", key, chainCode)
+	}
+
+	// 2. Generated phrases validate and restore deterministically
+	for _, n := range []int{12, 24} {
+		m, err := GenerateMnemonic(n)
+		if err != nil || len(strings.Fields(m)) != n {
+			t.Fatalf("GenerateMnemonic(%d) = %q, %v", n, m, err)
+		}
+		if err := ValidateMnemonic(m); err != nil {
+			t.Errorf("Generated mnemonic does not validate: %v", err)
+		}
+		k1, _ := KeyFromMnemonic(m, "")
+		k2, _ := KeyFromMnemonic("  "+strings.ToUpper(m)+"\n", "")
+		k3, _ := KeyFromMnemonic(m, "extra")
+		if k1 == "" || k1 != k2 || k1 == k3 {
+			t.Errorf("KeyFromMnemonic is not deterministic or ignores the passphrase")
+		}
+		if _, err := NewKeySignerFromBase64(k1); err != nil {
+			t.Errorf("Mnemonic key is not a valid seed: %v", err)
+		}
+	}
+	if _, err := GenerateMnemonic(13); !errors.Is(err, ErrInvalidMnemonic) {
+		t.Errorf("GenerateMnemonic(13) error = %v", err)
+	}
+
+	// 3. Passphrases are NFKD-normalized
+	composed, _ := KeyFromMnemonic(zero, "p\u00e4ss")
+	decomposed, _ := KeyFromMnemonic(zero, "pa\u0308ss")
+	if composed != decomposed {
+		t.Error("Passphrase is not normalized")
+	}
+
+	// 4. Malformed phrases are rejected
+	bad := map[string]string{
+		"checksum":  strings.Replace(zero, "about", "abandon", 1),
+		"word":      strings.Replace(zero, "about", "octra", 1),
+		"length":    "abandon abandon abandon",
+		"truncated": strings.Join(strings.Fields(legal)[:11], " "),
+	}
+	for name, m := range bad {
+		if _, err := KeyFromMnemonic(m, ""); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("%s: KeyFromMnemonic error = %v", name, err)
+		}
+	}
+}
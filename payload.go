@@ -0,0 +1,131 @@
+package osm15
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PayloadVersion is the SignedPayload format written by
+// ExportSignedPayload.
+const PayloadVersion = 2
+
+// AlgorithmEd25519 is the only signature algorithm defined by OSM-15.
+const AlgorithmEd25519 = "ed25519"
+
+// ErrNoPublicKey is returned when no key was supplied to verify with, or
+// when a payload verified by its embedded key does not name its signer.
+var ErrNoPublicKey = errors.New("payload does not name its signer")
+
+// NewSignedPayload returns a version 2 payload for data signed by pub.
+func NewSignedPayload(data TypedData, signatureB64 string, pub ed25519.PublicKey) SignedPayload {
+	return SignedPayload{
+		Version:   PayloadVersion,
+		Algorithm: AlgorithmEd25519,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		Address:   PublicKeyToAddress(pub),
+		Data:      data,
+		Signature: signatureB64,
+	}
+}
+
+// ExportSignedPayload is ExportToJSON for a version 2 payload, which names
+// its signer.
+func ExportSignedPayload(data TypedData, signatureB64 string, pub ed25519.PublicKey) ([]byte, error) {
+	return json.MarshalIndent(NewSignedPayload(data, signatureB64, pub), "", "  ")
+}
+
+// ParseSignedPayload decodes a version 1 or 2 payload. Version 1 payloads
+// come back with Version set to 1. Version 2 payloads must use Ed25519
+// and their address must match their public key.
+func ParseSignedPayload(payloadJSON []byte) (SignedPayload, error) {
+	var p SignedPayload
+	if err := json.Unmarshal(payloadJSON, &p); err != nil {
+		return p, err
+	}
+	if p.Version == 0 {
+		p.Version = 1
+	}
+	return p, p.checkSigner()
+}
+
+// Verify checks a version 2 payload against its embedded public key and
+// returns the signer's address. It returns ErrNoPublicKey for version 1
+// payloads and an error wrapping ErrBadSignature if the signature is
+// invalid. A valid result only says who signed; the caller decides
+// whether that address is trusted.
+func (p SignedPayload) Verify() (string, error) {
+	pub, err := p.embeddedKey()
+	if err != nil {
+		return "", err
+	}
+	valid, err := VerifyTypedData(p.Data, p.Signature, pub)
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		return "", fmt.Errorf("osm15: payload from %s: %w", p.Address, ErrBadSignature)
+	}
+	return p.Address, nil
+}
+
+// checkSigner validates the signer fields for the payload's version.
+func (p SignedPayload) checkSigner() error {
+	switch p.Version {
+	case 0, 1:
+		if p.PublicKey != "" || p.Address != "" || p.Algorithm != "" {
+			return errors.New("osm15: version 1 payloads do not carry signer fields")
+		}
+		return nil
+	case PayloadVersion:
+	default:
+		return fmt.Errorf("osm15: unsupported payload version %d", p.Version)
+	}
+
+	if p.Algorithm != AlgorithmEd25519 {
+		return fmt.Errorf("osm15: unsupported signature algorithm %q", p.Algorithm)
+	}
+	pub, err := base64.StdEncoding.DecodeString(p.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: payload public key must be a base64 Ed25519 key", ErrInvalidKey)
+	}
+	if p.Address != PublicKeyToAddress(pub) {
+		return fmt.Errorf("osm15: payload address %s does not match its public key", p.Address)
+	}
+	return nil
+}
+
+// signerKey checks that the caller's publicKeyB64 may verify p: it is
+// required, and must be the embedded key if p names one. An embedded key
+// is never trusted on its own here.
+func (p SignedPayload) signerKey(publicKeyB64 string) (string, error) {
+	if err := p.checkSigner(); err != nil {
+		return "", err
+	}
+	if publicKeyB64 == "" {
+		return "", fmt.Errorf("%w: a public key is required", ErrNoPublicKey)
+	}
+	if p.PublicKey != "" {
+		want := decodePublicKey(publicKeyB64)
+		if want == nil {
+			return "", fmt.Errorf("%w: public key must be a base64 Ed25519 key", ErrInvalidKey)
+		}
+		if !want.Equal(decodePublicKey(p.PublicKey)) {
+			return "", fmt.Errorf("osm15: payload is signed by %s: %w", p.Address, ErrBadSignature)
+		}
+	}
+	return publicKeyB64, nil
+}
+
+// embeddedKey returns the key a version 2 payload names.
+func (p SignedPayload) embeddedKey() (string, error) {
+	if err := p.checkSigner(); err != nil {
+		return "", err
+	}
+	if p.PublicKey == "" {
+		return "", ErrNoPublicKey
+	}
+	return p.PublicKey, nil
+}
@@ -0,0 +1,329 @@
+package osm15
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrPolicyViolation is wrapped by every PolicyError.
+var ErrPolicyViolation = errors.New("rejected by signing policy")
+
+// PolicyError reports why a Policy rejected typed data. Path locates the
+// offending value, e.g. "primaryType" or "message.orders[1].amount".
+type PolicyError struct {
+	Path   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("policy: %s: %s", e.Path, e.Reason)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrPolicyViolation
+}
+
+// Policy is a declarative allow-list checked against typed data before it
+// is signed. Anything not explicitly allowed is rejected:
+//
+//	{
+//	  "domains": [{"name": "Octra", "chainIds": [1]}],
+//	  "types": {
+//	    "Transfer": {
+//	      "fields": {
+//	        "amount": {"max": "1000000"},
+//	        "to":     {"allowed": ["oct...", "oct..."]}
+//	      }
+//	    }
+//	  }
+//	}
+type Policy struct {
+	// Domains lists the accepted domains; data must match at least one.
+	Domains []DomainRule `json:"domains"`
+	// Types maps each accepted primaryType to the constraints on its
+	// message. Primary types that are not listed are rejected.
+	Types map[string]TypeRule `json:"types"`
+}
+
+// DomainRule matches a TypedDomain. Empty Name, Version, ChainIDs and
+// VerifyingContracts match any value. Salts and extensions fail closed: a
+// domain with a salt matches only if Salts lists it, and one with
+// extensions only if Extensions has a rule for each of their names that
+// the value passes. An empty FieldRule allows any value.
+type DomainRule struct {
+	Name               string               `json:"name,omitempty"`
+	Version            string               `json:"version,omitempty"`
+	ChainIDs           []int                `json:"chainIds,omitempty"`
+	VerifyingContracts []string             `json:"verifyingContracts,omitempty"`
+	Salts              []string             `json:"salts,omitempty"`
+	Extensions         map[string]FieldRule `json:"extensions,omitempty"`
+}
+
+// TypeRule constrains the message of one primary type. Field paths are
+// member names separated by dots; "[]" after a name applies the rule to
+// every element of an array, e.g. "orders[].amount".
+type TypeRule struct {
+	Fields map[string]FieldRule `json:"fields,omitempty"`
+}
+
+// FieldRule constrains one message field. Min and Max are decimal integer
+// bounds and apply to integer fields only. Allowed lists the accepted
+// values; integers compare numerically, everything else as strings.
+type FieldRule struct {
+	Min     string   `json:"min,omitempty"`
+	Max     string   `json:"max,omitempty"`
+	Allowed []string `json:"allowed,omitempty"`
+}
+
+// LoadPolicy reads and parses a policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses a JSON policy and checks that its bounds are valid
+// integers. Unknown keys are rejected so that a misspelt constraint is not
+// silently ignored.
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("policy: %v", err)
+	}
+	if len(p.Domains) == 0 {
+		return nil, errors.New("policy: at least one domain rule is required")
+	}
+	if len(p.Types) == 0 {
+		return nil, errors.New("policy: at least one primary type is required")
+	}
+	for i, rule := range p.Domains {
+		for name, field := range rule.Extensions {
+			if _, _, err := field.bounds(); err != nil {
+				return nil, fmt.Errorf("policy: domains[%d].extensions.%s: %v", i, name, err)
+			}
+		}
+	}
+	for typeName, rule := range p.Types {
+		for path, field := range rule.Fields {
+			if _, _, err := field.bounds(); err != nil {
+				return nil, fmt.Errorf("policy: types.%s.fields.%s: %v", typeName, path, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Check returns nil if p allows data to be signed, or a *PolicyError
+// describing the first violation. Data that fails schema validation or
+// encoding is rejected as well.
+func (p *Policy) Check(data TypedData) error {
+	if diags := data.Validate(); len(diags) > 0 {
+		return &SchemaError{Diagnostics: diags}
+	}
+	if _, err := HashTypedData(data); err != nil {
+		return err
+	}
+
+	if !p.domainAllowed(data.Domain) {
+		return &PolicyError{Path: "domain", Reason: fmt.Sprintf("domain %q version %q chainId %d is not allowed", data.Domain.Name, data.Domain.Version, data.Domain.ChainID)}
+	}
+	rule, ok := p.Types[data.PrimaryType]
+	if !ok {
+		return &PolicyError{Path: "primaryType", Reason: fmt.Sprintf("%q is not allowed", data.PrimaryType)}
+	}
+	for _, fieldPath := range sortedKeys(rule.Fields) {
+		field := rule.Fields[fieldPath]
+		segments := strings.Split(fieldPath, ".")
+		if err := checkField(data.Types, data.PrimaryType, "message", data.Message, segments, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Policy) domainAllowed(d TypedDomain) bool {
+	for _, r := range p.Domains {
+		if r.Name != "" && r.Name != d.Name {
+			continue
+		}
+		if r.Version != "" && r.Version != d.Version {
+			continue
+		}
+		if len(r.ChainIDs) > 0 && !containsInt(r.ChainIDs, d.ChainID) {
+			continue
+		}
+		if len(r.VerifyingContracts) > 0 && !containsString(r.VerifyingContracts, d.VerifyingContract) {
+			continue
+		}
+		if d.Salt != "" && !containsFold(r.Salts, d.Salt) {
+			continue
+		}
+		if !r.extensionsAllowed(d.Extensions) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (r DomainRule) extensionsAllowed(extensions []DomainField) bool {
+	for _, ext := range extensions {
+		rule, ok := r.Extensions[ext.Name]
+		if !ok || rule.check("domain."+ext.Name, ext.Type, ext.Value) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkField walks segments through the struct typeName held in value and
+// applies rule to every value found.
+func checkField(types map[string][]TypedMember, typeName, path string, value interface{}, segments []string, rule FieldRule) error {
+	name := segments[0]
+	arrays := 0
+	for strings.HasSuffix(name, "[]") {
+		name = strings.TrimSuffix(name, "[]")
+		arrays++
+	}
+
+	var memberType string
+	for _, m := range types[typeName] {
+		if m.Name == name {
+			memberType = m.Type
+		}
+	}
+	if memberType == "" {
+		return &PolicyError{Path: path + "." + name, Reason: fmt.Sprintf("%s has no member %q", typeName, name)}
+	}
+	fields, _ := value.(map[string]interface{})
+	return checkValue(types, memberType, path+"."+name, fields[name], arrays, segments[1:], rule)
+}
+
+func checkValue(types map[string][]TypedMember, typeName, path string, value interface{}, arrays int, rest []string, rule FieldRule) error {
+	if arrays > 0 {
+		elemType, _, ok, _ := splitArrayType(typeName)
+		if !ok {
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("%s is not an array", typeName)}
+		}
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("expected an array, got %T", value)}
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := checkValue(types, elemType, fmt.Sprintf("%s[%d]", path, i), rv.Index(i).Interface(), arrays-1, rest, rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(rest) > 0 {
+		if _, ok := types[typeName]; !ok {
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("%s is not a struct", typeName)}
+		}
+		return checkField(types, typeName, path, value, rest, rule)
+	}
+	return rule.check(path, typeName, value)
+}
+
+// bounds parses Min and Max; a nil bound is unset.
+func (r FieldRule) bounds() (min, max *big.Int, err error) {
+	if r.Min != "" {
+		if min, err = parseInteger(r.Min); err != nil {
+			return nil, nil, fmt.Errorf("min: %v", err)
+		}
+	}
+	if r.Max != "" {
+		if max, err = parseInteger(r.Max); err != nil {
+			return nil, nil, fmt.Errorf("max: %v", err)
+		}
+	}
+	return min, max, nil
+}
+
+func (r FieldRule) check(path, typeName string, value interface{}) error {
+	min, max, err := r.bounds()
+	if err != nil {
+		return &PolicyError{Path: path, Reason: err.Error()}
+	}
+	if _, _, isInt := parseIntType(typeName); isInt {
+		n, err := toBigInt(value)
+		if err != nil {
+			return &PolicyError{Path: path, Reason: err.Error()}
+		}
+		if min != nil && n.Cmp(min) < 0 {
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("%s is below the minimum %s", n, min)}
+		}
+		if max != nil && n.Cmp(max) > 0 {
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("%s exceeds the maximum %s", n, max)}
+		}
+		if len(r.Allowed) > 0 {
+			for _, a := range r.Allowed {
+				if allowed, err := parseInteger(a); err == nil && allowed.Cmp(n) == 0 {
+					return nil
+				}
+			}
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("%s is not an allowed value", n)}
+		}
+		return nil
+	}
+
+	if min != nil || max != nil {
+		return &PolicyError{Path: path, Reason: fmt.Sprintf("min/max apply to integer fields, not %s", typeName)}
+	}
+	if len(r.Allowed) > 0 {
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		if !containsString(r.Allowed, s) {
+			return &PolicyError{Path: path, Reason: fmt.Sprintf("%q is not an allowed value", s)}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]FieldRule) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, v string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
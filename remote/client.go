@@ -0,0 +1,154 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dayuwidayadi57/osm15"
+)
+
+// ErrDigestOnly is returned by a remote Signer's SignDigest: the daemon
+// only signs typed data it can inspect. Use osm15.SignTypedDataWith.
+var ErrDigestOnly = errors.New("remote: the signing daemon does not sign bare digests")
+
+// APIError is an error reported by the daemon.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("remote: %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to a signing daemon.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for the daemon at baseURL.
+func NewClient(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+// Keys lists the keys the daemon can sign with.
+func (c *Client) Keys(ctx context.Context) ([]Key, error) {
+	var keys []Key
+	err := c.do(ctx, http.MethodGet, "/v1/address", nil, &keys)
+	return keys, err
+}
+
+// Hash returns the digest of data as computed by the daemon.
+func (c *Client) Hash(ctx context.Context, data osm15.TypedData) ([]byte, error) {
+	var resp HashResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/hash", data, &resp); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(resp.Digest)
+}
+
+// Sign asks the daemon to sign data with the key for address.
+func (c *Client) Sign(ctx context.Context, address string, data osm15.TypedData) (*SignResponse, error) {
+	var resp SignResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/sign", SignRequest{Address: address, Data: data}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Verify asks the daemon to check a signature.
+func (c *Client) Verify(ctx context.Context, data osm15.TypedData, signatureB64, publicKeyB64 string) (*VerifyResponse, error) {
+	var resp VerifyResponse
+	req := VerifyRequest{Data: data, Signature: signatureB64, PublicKey: publicKeyB64}
+	if err := c.do(ctx, http.MethodPost, "/v1/verify", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Signer returns an osm15.Signer that signs through the daemon with the
+// key for address, or with the daemon's only key when address is empty.
+func (c *Client) Signer(ctx context.Context, address string) (osm15.Signer, error) {
+	keys, err := c.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if address != "" && k.Address != address {
+			continue
+		}
+		if address == "" && len(keys) != 1 {
+			return nil, errors.New("remote: daemon holds several keys; choose an address")
+		}
+		pub, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize || osm15.PublicKeyToAddress(pub) != k.Address {
+			return nil, fmt.Errorf("remote: daemon returned a malformed key for %s", k.Address)
+		}
+		return &remoteSigner{client: c, address: k.Address, pub: pub}, nil
+	}
+	return nil, fmt.Errorf("remote: daemon holds no key for %q", address)
+}
+
+type remoteSigner struct {
+	client  *Client
+	address string
+	pub     ed25519.PublicKey
+}
+
+func (s *remoteSigner) Public() ed25519.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return nil, ErrDigestOnly
+}
+
+func (s *remoteSigner) SignTypedData(ctx context.Context, data osm15.TypedData) ([]byte, error) {
+	resp, err := s.client.Sign(ctx, s.address, data)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return &APIError{StatusCode: resp.StatusCode, Message: e.Error}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
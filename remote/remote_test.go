@@ -0,0 +1,150 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/audit"
+)
+
+func testData() osm15.TypedData {
+	return osm15.TypedData{
+		Domain:      osm15.TypedDomain{Name: "Remote", Version: "1", ChainID: 1},
+		Types:       map[string][]osm15.TypedMember{"Pay": {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}}},
+		PrimaryType: "Pay",
+		Message:     map[string]interface{}{"to": "oct8EjkXVSTxMFjCvNNsTo8RBMDEVQmk7gYkW4SCDuvdsBG", "amount": "18446744073709551617"},
+	}
+}
+
+// newTestServer is remotetest.NewServer, which this package cannot import.
+func newTestServer(signers ...osm15.Signer) (*httptest.Server, *Client, error) {
+	const token = "osm15-test-token"
+	srv, err := NewServer(token, signers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts := httptest.NewServer(srv)
+	client := NewClient(ts.URL, token)
+	client.HTTPClient = ts.Client()
+	return ts, client, nil
+}
+
+func TestRemote_SignThroughDaemon(t *testing.T) {
+	ctx := context.Background()
+	privA, pubA, _ := osm15.GenerateKeypair()
+	privB, _, _ := osm15.GenerateKeypair()
+	signerA, _ := osm15.NewKeySignerFromBase64(privA)
+	signerB, _ := osm15.NewKeySignerFromBase64(privB)
+	addrA := osm15.PublicKeyToAddress(signerA.Public())
+
+	ts, client, err := newTestServer(signerA, signerB)
+	if err != nil {
+		t.Fatalf("newTestServer error: %v", err)
+	}
+	defer ts.Close()
+	data := testData()
+
+	// 1. Keys and hashes match the library
+	keys, err := client.Keys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Keys: %v %v", keys, err)
+	}
+	digest, err := client.Hash(ctx, data)
+	want, _ := osm15.HashTypedData(data)
+	if err != nil || !bytes.Equal(digest, want) {
+		t.Errorf("Remote digest differs: %v", err)
+	}
+
+	// 2. The client is a transparent osm15.Signer
+	remoteSigner, err := client.Signer(ctx, addrA)
+	if err != nil {
+		t.Fatalf("Signer error: %v", err)
+	}
+	sig, err := osm15.SignTypedDataWith(ctx, data, remoteSigner)
+	if err != nil {
+		t.Fatalf("Remote sign error: %v", err)
+	}
+	if valid, _ := osm15.VerifyTypedData(data, sig, pubA); !valid {
+		t.Error("Remote signature does not verify locally")
+	}
+	if _, err := remoteSigner.SignDigest(ctx, want); !errors.Is(err, ErrDigestOnly) {
+		t.Errorf("Bare digest signing should be refused, got %v", err)
+	}
+	if _, err := client.Signer(ctx, ""); err == nil {
+		t.Error("Ambiguous key selection accepted")
+	}
+
+	// 3. Verify endpoint
+	res, err := client.Verify(ctx, data, sig, pubA)
+	if err != nil || !res.Valid || res.Address != addrA {
+		t.Errorf("Verify: %+v %v", res, err)
+	}
+	data.Message["amount"] = 1
+	if res, _ := client.Verify(ctx, data, sig, pubA); res.Valid {
+		t.Error("Tampered data verified")
+	}
+
+	// 4. Bad schemas and bad tokens are rejected
+	bad := testData()
+	bad.Types["Pay"][1].Type = "uint265"
+	var apiErr *APIError
+	if _, err := client.Sign(ctx, addrA, bad); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %v", err)
+	}
+	intruder := NewClient(ts.URL, "wrong-token")
+	if _, err := intruder.Keys(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %v", err)
+	}
+}
+
+func TestRemote_NewServerChecks(t *testing.T) {
+	priv, _, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(priv)
+	if _, err := NewServer("", signer); err == nil {
+		t.Error("Server without token accepted")
+	}
+	if _, err := NewServer("token", signer, signer); err == nil {
+		t.Error("Duplicate key accepted")
+	}
+}
+
+func TestRemote_PolicyAndAudit(t *testing.T) {
+	ctx := context.Background()
+	priv, _, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(priv)
+	srv, _ := NewServer("token", signer)
+	srv.Policy, _ = osm15.ParsePolicy([]byte(`{"domains": [{"name": "Remote"}], "types": {"Pay": {"fields": {"amount": {"max": "1000"}}}}}`))
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	srv.AuditLog, _ = audit.Open(logPath)
+	defer srv.AuditLog.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client := NewClient(ts.URL, "token")
+
+	small := testData()
+	small.Message["amount"] = "10"
+	if _, err := client.Sign(ctx, "", small); err != nil {
+		t.Fatalf("Allowed request failed: %v", err)
+	}
+	var apiErr *APIError
+	if _, err := client.Sign(ctx, "", testData()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", err)
+	}
+
+	records, err := audit.Records(logPath)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Records = %v, %v", records, err)
+	}
+	if records[0].Decision != audit.DecisionAllowed || records[1].Decision != audit.DecisionRejected {
+		t.Errorf("Decisions = %s, %s", records[0].Decision, records[1].Decision)
+	}
+	if _, err := audit.Verify(logPath); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
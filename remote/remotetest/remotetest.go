@@ -0,0 +1,26 @@
+// Package remotetest runs an in-process signing daemon for tests of code
+// that talks to one through remote.Client.
+package remotetest
+
+import (
+	"net/http/httptest"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/remote"
+)
+
+// Token is the API token of servers started by NewServer.
+const Token = "osm15-test-token"
+
+// NewServer starts a daemon for signers on a loopback port and returns it
+// with a Client authenticated against it. Close the server when done.
+func NewServer(signers ...osm15.Signer) (*httptest.Server, *remote.Client, error) {
+	srv, err := remote.NewServer(Token, signers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts := httptest.NewServer(srv)
+	client := remote.NewClient(ts.URL, Token)
+	client.HTTPClient = ts.Client()
+	return ts, client, nil
+}
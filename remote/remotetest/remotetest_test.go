@@ -0,0 +1,23 @@
+package remotetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dayuwidayadi57/osm15"
+)
+
+func TestRemotetest_NewServer(t *testing.T) {
+	priv, pub, _ := osm15.GenerateKeypair()
+	signer, _ := osm15.NewKeySignerFromBase64(priv)
+	ts, client, err := NewServer(signer)
+	if err != nil {
+		t.Fatalf("NewServer error: %v", err)
+	}
+	defer ts.Close()
+
+	keys, err := client.Keys(context.Background())
+	if err != nil || len(keys) != 1 || keys[0].PublicKey != pub {
+		t.Errorf("Keys = %+v, %v", keys, err)
+	}
+}
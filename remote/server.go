@@ -0,0 +1,235 @@
+// Package remote implements the OSM-15 signing daemon behind `osm15 serve`
+// and a client that uses it as an osm15.Signer.
+//
+// The daemon holds unlocked keys in memory and exposes JSON endpoints,
+// all authenticated with a bearer token:
+//
+//	GET  /v1/address   list the keys the daemon can sign with
+//	POST /v1/hash      TypedData -> digest
+//	POST /v1/sign      {address, data} -> signature
+//	POST /v1/verify    {data, signature, publicKey} -> valid
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/audit"
+)
+
+// maxRequestBytes bounds the size of a request body.
+const maxRequestBytes = 1 << 20
+
+// Key describes a key the daemon can sign with.
+type Key struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"publicKey"`
+}
+
+// SignRequest asks the daemon to sign data. Address may be empty when the
+// daemon holds exactly one key.
+type SignRequest struct {
+	Address string          `json:"address,omitempty"`
+	Data    osm15.TypedData `json:"data"`
+}
+
+// SignResponse carries a signature and the key that produced it.
+type SignResponse struct {
+	Signature string `json:"signature"`
+	PublicKey string `json:"publicKey"`
+	Address   string `json:"address"`
+	Digest    string `json:"digest"`
+}
+
+// VerifyRequest asks the daemon to check a signature.
+type VerifyRequest struct {
+	Data      osm15.TypedData `json:"data"`
+	Signature string          `json:"signature"`
+	PublicKey string          `json:"publicKey"`
+}
+
+// VerifyResponse reports whether a signature is valid and for which address.
+type VerifyResponse struct {
+	Valid   bool   `json:"valid"`
+	Address string `json:"address,omitempty"`
+}
+
+// HashResponse carries the hex digest of a TypedData.
+type HashResponse struct {
+	Digest string `json:"digest"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server is an http.Handler serving the signing API.
+type Server struct {
+	// Policy, when set, must allow every request before it is signed.
+	Policy *osm15.Policy
+	// AuditLog, when set, records every sign request.
+	AuditLog *audit.Log
+
+	token   string
+	signers map[string]osm15.Signer
+	mux     *http.ServeMux
+}
+
+// NewServer returns a Server that signs with signers and accepts requests
+// bearing token.
+func NewServer(token string, signers ...osm15.Signer) (*Server, error) {
+	if token == "" {
+		return nil, errors.New("remote: an API token is required")
+	}
+	s := &Server{
+		token:   token,
+		signers: make(map[string]osm15.Signer),
+		mux:     http.NewServeMux(),
+	}
+	for _, signer := range signers {
+		addr := osm15.PublicKeyToAddress(signer.Public())
+		if _, ok := s.signers[addr]; ok {
+			return nil, fmt.Errorf("remote: key %s added twice", addr)
+		}
+		s.signers[addr] = signer
+	}
+
+	s.mux.HandleFunc("GET /v1/address", s.handleAddress)
+	s.mux.HandleFunc("POST /v1/hash", s.handleHash)
+	s.mux.HandleFunc("POST /v1/sign", s.handleSign)
+	s.mux.HandleFunc("POST /v1/verify", s.handleVerify)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleAddress(w http.ResponseWriter, r *http.Request) {
+	keys := make([]Key, 0, len(s.signers))
+	for addr, signer := range s.signers {
+		keys = append(keys, Key{Address: addr, PublicKey: base64.StdEncoding.EncodeToString(signer.Public())})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Address < keys[j].Address })
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (s *Server) handleHash(w http.ResponseWriter, r *http.Request) {
+	var data osm15.TypedData
+	if !readJSON(w, r, &data) {
+		return
+	}
+	digest, err := osm15.HashTypedData(data)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, HashResponse{Digest: hex.EncodeToString(digest)})
+}
+
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	var req SignRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	signer, err := s.signer(req.Address)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	sig, err := s.AuditLog.Sign(r.Context(), req.Data, signer, s.Policy, "remote:"+r.RemoteAddr)
+	if errors.Is(err, osm15.ErrPolicyViolation) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if errors.Is(err, audit.ErrCorrupt) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	digest, _ := osm15.HashTypedData(req.Data)
+
+	writeJSON(w, http.StatusOK, SignResponse{
+		Signature: sig,
+		PublicKey: base64.StdEncoding.EncodeToString(signer.Public()),
+		Address:   osm15.PublicKeyToAddress(signer.Public()),
+		Digest:    hex.EncodeToString(digest),
+	})
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var req VerifyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	pub, err := base64.StdEncoding.DecodeString(req.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		writeError(w, http.StatusBadRequest, errors.New("publicKey must be a base64 Ed25519 public key"))
+		return
+	}
+	valid, err := osm15.VerifyTypedData(req.Data, req.Signature, req.PublicKey)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	resp := VerifyResponse{Valid: valid}
+	if valid {
+		resp.Address = osm15.PublicKeyToAddress(pub)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// signer picks the key for address, or the only key when address is empty.
+func (s *Server) signer(address string) (osm15.Signer, error) {
+	if address == "" {
+		if len(s.signers) == 1 {
+			for _, signer := range s.signers {
+				return signer, nil
+			}
+		}
+		return nil, errors.New("address is required when the daemon holds several keys")
+	}
+	signer, ok := s.signers[address]
+	if !ok {
+		return nil, fmt.Errorf("no key for address %s", address)
+	}
+	return signer, nil
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,380 @@
+package osm15
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dayuwidayadi57/osm15/internal/atomicfile"
+	"github.com/dayuwidayadi57/osm15/internal/filelock"
+)
+
+// Conventional message fields checked by Verifier. validAfter and deadline
+// are Unix timestamps in seconds and must be declared as integers.
+const (
+	FieldNonce      = "nonce"
+	FieldValidAfter = "validAfter"
+	FieldDeadline   = "deadline"
+)
+
+// Errors returned by Verifier.
+var (
+	ErrBadSignature = errors.New("signature does not verify")
+	ErrReplay       = errors.New("nonce already used")
+	ErrExpired      = errors.New("payload has expired")
+	ErrNotYetValid  = errors.New("payload is not valid yet")
+	ErrNoNonce      = errors.New("payload has no nonce")
+	ErrNoDeadline   = errors.New("payload has no deadline")
+)
+
+// NonceStore remembers nonces that have been accepted. Use must record key
+// and return ErrReplay if it was already recorded, atomically. expires is
+// when the entry may be forgotten because the payload it came from can no
+// longer verify; it is zero for payloads without a deadline.
+type NonceStore interface {
+	Use(key string, expires time.Time) error
+}
+
+// Verifier checks signatures together with the freshness fields of the
+// message, so a SignedPayload is accepted at most once and only inside its
+// validity window. The zero value checks timestamps but cannot detect
+// replays; set Store for that.
+type Verifier struct {
+	// Store records nonces. Without one, nonces are not checked.
+	Store NonceStore
+	// RequireNonce rejects messages without a nonce field.
+	RequireNonce bool
+	// RequireDeadline rejects messages without a deadline field.
+	RequireDeadline bool
+	// ClockSkew is tolerated on both ends of the validity window.
+	ClockSkew time.Duration
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// Verify checks payload's signature against publicKeyB64, then its
+// validAfter and deadline, and finally records its nonce. publicKeyB64 is
+// required; a version 2 payload must also name that key. The nonce is
+// scoped to the signer and the domain, so different signers and
+// applications may reuse the same values.
+func (v *Verifier) Verify(payload SignedPayload, publicKeyB64 string) error {
+	publicKeyB64, err := payload.signerKey(publicKeyB64)
+	if err != nil {
+		return err
+	}
+	return v.verify(payload, publicKeyB64)
+}
+
+// VerifySigned is Verify for a version 2 payload checked against the key
+// it embeds. It returns the signer's address, which the caller must still
+// check against the signers it trusts.
+func (v *Verifier) VerifySigned(payload SignedPayload) (string, error) {
+	publicKeyB64, err := payload.embeddedKey()
+	if err != nil {
+		return "", err
+	}
+	if err := v.verify(payload, publicKeyB64); err != nil {
+		return "", err
+	}
+	return payload.Address, nil
+}
+
+func (v *Verifier) verify(payload SignedPayload, publicKeyB64 string) error {
+	data := payload.Data
+	schema, err := CompileSchema(data.Types, data.PrimaryType)
+	if err != nil {
+		return err
+	}
+	valid, err := VerifyTypedData(data, payload.Signature, publicKeyB64)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrBadSignature
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	validAfter, err := timestampField(schema, data.Message, FieldValidAfter)
+	if err != nil {
+		return err
+	}
+	deadline, err := timestampField(schema, data.Message, FieldDeadline)
+	if err != nil {
+		return err
+	}
+	if !validAfter.IsZero() && !now.Add(v.ClockSkew).After(validAfter) {
+		return fmt.Errorf("%w: valid after %s", ErrNotYetValid, validAfter.UTC().Format(time.RFC3339))
+	}
+	if !deadline.IsZero() && now.Add(-v.ClockSkew).After(deadline) {
+		return fmt.Errorf("%w: deadline was %s", ErrExpired, deadline.UTC().Format(time.RFC3339))
+	}
+	if deadline.IsZero() && v.RequireDeadline {
+		return ErrNoDeadline
+	}
+
+	nonce, ok, err := nonceField(schema, data.Message)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if v.RequireNonce {
+			return ErrNoNonce
+		}
+		return nil
+	}
+	if v.Store == nil {
+		return nil
+	}
+	domainHash, err := schema.domainHash(data.Domain)
+	if err != nil {
+		return err
+	}
+	pub, _ := base64.StdEncoding.DecodeString(publicKeyB64)
+	key := PublicKeyToAddress(pub) + ":" + hex.EncodeToString(domainHash) + ":" + nonce
+	var expires time.Time
+	if !deadline.IsZero() {
+		expires = deadline.Add(v.ClockSkew)
+	}
+	return v.Store.Use(key, expires)
+}
+
+// VerifyJSON is Verify for a payload produced by ExportSignedPayload or
+// ExportToJSON.
+func (v *Verifier) VerifyJSON(payloadJSON []byte, publicKeyB64 string) error {
+	payload, err := ParseSignedPayload(payloadJSON)
+	if err != nil {
+		return err
+	}
+	return v.Verify(payload, publicKeyB64)
+}
+
+// memberType returns the declared type of a primary-type member, or "".
+func memberType(schema *Schema, name string) string {
+	for _, m := range schema.types[schema.primaryType] {
+		if m.Name == name {
+			return m.Type
+		}
+	}
+	return ""
+}
+
+// timestampField reads an integer Unix-seconds member; zero time means the
+// member is not declared.
+func timestampField(schema *Schema, message map[string]interface{}, name string) (time.Time, error) {
+	typeName := memberType(schema, name)
+	if typeName == "" {
+		return time.Time{}, nil
+	}
+	if _, _, ok := parseIntType(typeName); !ok {
+		return time.Time{}, &EncodeError{Path: "message." + name, Type: typeName, Err: fmt.Errorf("%w: must be an integer timestamp", ErrTypeMismatch)}
+	}
+	n, err := toBigInt(message[name])
+	if err != nil {
+		return time.Time{}, &EncodeError{Path: "message." + name, Type: typeName, Err: err}
+	}
+	if n.Sign() < 0 || n.Cmp(big.NewInt(1<<62)) > 0 {
+		return time.Time{}, &EncodeError{Path: "message." + name, Type: typeName, Err: fmt.Errorf("%w: timestamp %s", ErrOutOfRange, n)}
+	}
+	return time.Unix(n.Int64(), 0), nil
+}
+
+// nonceField returns the nonce in a canonical form, so that equal values
+// written differently (1, "1", "0x01") collide.
+func nonceField(schema *Schema, message map[string]interface{}) (string, bool, error) {
+	typeName := memberType(schema, FieldNonce)
+	if typeName == "" {
+		return "", false, nil
+	}
+	value := message[FieldNonce]
+	if _, _, ok := parseIntType(typeName); ok {
+		n, err := toBigInt(value)
+		if err != nil {
+			return "", false, err
+		}
+		return n.String(), true, nil
+	}
+	n, fixed := parseFixedBytesType(typeName)
+	if fixed || typeName == "bytes" {
+		b, err := toBytes(value)
+		if err != nil {
+			return "", false, err
+		}
+		if fixed && len(b) < n {
+			// bytesN values are right-padded when encoded.
+			b = append(b, make([]byte, n-len(b))...)
+		}
+		return hex.EncodeToString(b), true, nil
+	}
+	return fmt.Sprint(value), true, nil
+}
+
+// MemoryNonceStore is an in-memory NonceStore. Entries are dropped once
+// they expire. Its zero value is ready to use.
+type MemoryNonceStore struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	uses    int
+}
+
+// seen reports whether key has been recorded.
+func (s *MemoryNonceStore) seen(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[key]
+	return ok
+}
+
+// Use implements NonceStore.
+func (s *MemoryNonceStore) Use(key string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]time.Time)
+	}
+	if _, ok := s.entries[key]; ok {
+		return ErrReplay
+	}
+	s.entries[key] = expires
+
+	// Prune now and then rather than on every call.
+	s.uses++
+	if s.uses%1024 == 0 {
+		now := time.Now()
+		for k, exp := range s.entries {
+			if !exp.IsZero() && now.After(exp) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return nil
+}
+
+// FileNonceStore is a NonceStore persisted to an append-only file, so that
+// nonces stay used across restarts. Expired entries are dropped when the
+// file is opened. Only one process may have a store open at a time; it is
+// locked through path+".lock".
+type FileNonceStore struct {
+	mu   sync.Mutex
+	mem  MemoryNonceStore
+	file *os.File
+	size int64
+	lock *os.File
+}
+
+// OpenFileNonceStore loads the store at path, creating it if needed.
+func OpenFileNonceStore(path string) (*FileNonceStore, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := filelock.TryLock(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("osm15: nonce store %s: %w", path, err)
+	}
+	s, err := openFileNonceStore(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.lock = lock
+	return s, nil
+}
+
+func openFileNonceStore(path string) (*FileNonceStore, error) {
+	entries := make(map[string]time.Time)
+	now := time.Now()
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			expiry, key, ok := strings.Cut(scanner.Text(), " ")
+			sec, err := strconv.ParseInt(expiry, 10, 64)
+			if !ok || err != nil {
+				f.Close()
+				return nil, fmt.Errorf("osm15: nonce store %s: line %d is malformed", path, line)
+			}
+			var exp time.Time
+			if sec != 0 {
+				exp = time.Unix(sec, 0)
+			}
+			if exp.IsZero() || !now.After(exp) {
+				entries[key] = exp
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Rewrite without the expired entries, then append from there.
+	var buf bytes.Buffer
+	for key, exp := range entries {
+		fmt.Fprintf(&buf, "%d %s\n", unixOrZero(exp), key)
+	}
+	if err := atomicfile.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNonceStore{mem: MemoryNonceStore{entries: entries}, file: file, size: int64(buf.Len())}, nil
+}
+
+// Use implements NonceStore. The nonce is on disk before Use returns, and
+// is only remembered once it is: if the write fails, the file is cut back
+// and the nonce stays unused.
+func (s *FileNonceStore) Use(key string, expires time.Time) error {
+	if strings.Contains(key, "\n") {
+		return fmt.Errorf("%w: nonce contains a newline", ErrTypeMismatch)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem.seen(key) {
+		return ErrReplay
+	}
+	line := fmt.Sprintf("%d %s\n", unixOrZero(expires), key)
+	_, err := s.file.WriteString(line)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(line))
+	return s.mem.Use(key, expires)
+}
+
+// Close closes the store's file and releases its lock.
+func (s *FileNonceStore) Close() error {
+	err := s.file.Close()
+	if s.lock != nil {
+		filelock.Unlock(s.lock)
+		s.lock.Close()
+	}
+	return err
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,379 @@
+package osm15
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Schema is a message type compiled once from TypedData.Types: type strings,
+// type hashes and per-member encoders are computed up front so hashing a
+// message only walks the values. A Schema is safe for concurrent use.
+type Schema struct {
+	primaryType string
+	types       map[string][]TypedMember
+	structs     map[string]*compiledStruct
+	root        *compiledStruct
+
+	domains sync.Map // domain type string -> *Schema, without extensions
+}
+
+type compiledStruct struct {
+	name       string
+	typeString string
+	typeHash   [32]byte
+	members    []compiledMember
+	declared   map[string]bool
+}
+
+type compiledMember struct {
+	name   string
+	typ    string
+	encode valueEncoder
+}
+
+// valueEncoder encodes one value to its 32-byte word. path locates the
+// value for error messages. When tr is non-nil the encoder records nested
+// struct and array traces into it.
+type valueEncoder func(path string, value interface{}, tr *ValueTrace) ([]byte, error)
+
+// CompileSchema compiles primaryType and every struct it references. The
+// reserved "TypedDomain" entry of types is ignored.
+func CompileSchema(types map[string][]TypedMember, primaryType string) (*Schema, error) {
+	if primaryType == "" {
+		return nil, &EncodeError{Path: "primaryType", Err: ErrUnknownType}
+	}
+	clean := make(map[string][]TypedMember, len(types))
+	for name, members := range types {
+		if name != "TypedDomain" {
+			clean[name] = members
+		}
+	}
+	return compileSchema(clean, primaryType)
+}
+
+func compileSchema(types map[string][]TypedMember, primaryType string) (*Schema, error) {
+	if _, ok := types[primaryType]; !ok {
+		return nil, &EncodeError{Path: "primaryType", Type: primaryType, Err: ErrUnknownType}
+	}
+
+	s := &Schema{
+		primaryType: primaryType,
+		types:       types,
+		structs:     make(map[string]*compiledStruct),
+	}
+
+	// Allocate every reachable struct first so that member encoders can
+	// refer to structs that are still being compiled.
+	deps := findDependencies(primaryType, types, make(map[string]bool))
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		typeString := encodeType(name, types)
+		s.structs[name] = &compiledStruct{
+			name:       name,
+			typeString: typeString,
+			typeHash:   sha256.Sum256([]byte(typeString)),
+			declared:   make(map[string]bool),
+		}
+	}
+
+	for _, name := range names {
+		cs := s.structs[name]
+		for i, member := range types[name] {
+			enc, err := s.compileEncoder(member.Type)
+			if err != nil {
+				return nil, &EncodeError{Path: fmt.Sprintf("types.%s[%d]", name, i), Type: member.Type, Err: err}
+			}
+			cs.members = append(cs.members, compiledMember{name: member.Name, typ: member.Type, encode: enc})
+			cs.declared[member.Name] = true
+		}
+	}
+
+	s.root = s.structs[primaryType]
+	return s, nil
+}
+
+// PrimaryType returns the name of the message type the schema encodes.
+func (s *Schema) PrimaryType() string {
+	return s.primaryType
+}
+
+// TypeString returns the encoded type string of the primary type.
+func (s *Schema) TypeString() string {
+	return s.root.typeString
+}
+
+// HashStruct returns the struct hash of message without the domain.
+func (s *Schema) HashStruct(message map[string]interface{}) ([]byte, error) {
+	return s.root.hash("message", message, nil)
+}
+
+// Hash returns the same digest as HashTypedData for a TypedData with this
+// schema, domain and message.
+func (s *Schema) Hash(domain TypedDomain, message map[string]interface{}) ([]byte, error) {
+	body, err := s.signingBody(domain, message)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(body)
+	return hash[:], nil
+}
+
+func (s *Schema) signingBody(domain TypedDomain, message map[string]interface{}) ([]byte, error) {
+	domainHash, err := s.domainHash(domain)
+	if err != nil {
+		return nil, err
+	}
+	messageHash, err := s.root.hash("message", message, nil)
+	if err != nil {
+		return nil, err
+	}
+	return signingBody(domainHash, messageHash), nil
+}
+
+// domainHash hashes domain with its derived type. Extension fields are
+// atomic, so the domain type never refers to the schema's struct types.
+// The compiled domain type is cached only without extensions: the type
+// then depends on which standard fields are set, so there are at most 32.
+func (s *Schema) domainHash(domain TypedDomain) ([]byte, error) {
+	if err := domain.validate(); err != nil {
+		return nil, err
+	}
+
+	types := map[string][]TypedMember{"TypedDomain": domain.Members()}
+	cacheable := len(domain.Extensions) == 0
+	var key string
+	if cacheable {
+		key = encodeType("TypedDomain", types)
+		if ds, ok := s.domains.Load(key); ok {
+			return ds.(*Schema).root.hash("domain", domain.ToMap(), nil)
+		}
+	}
+	ds, err := compileSchema(types, "TypedDomain")
+	if err != nil {
+		return nil, err
+	}
+	if cacheable {
+		s.domains.Store(key, ds)
+	}
+	return ds.root.hash("domain", domain.ToMap(), nil)
+}
+
+func (s *Schema) compileEncoder(typeName string) (valueEncoder, error) {
+	elemType, length, isArray, err := splitArrayType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if isArray {
+		elem, err := s.compileEncoder(elemType)
+		if err != nil {
+			return nil, err
+		}
+		return arrayEncoder(typeName, elemType, length, elem), nil
+	}
+
+	if cs, ok := s.structs[typeName]; ok {
+		return func(path string, value interface{}, tr *ValueTrace) ([]byte, error) {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, mismatch(path, typeName, value)
+			}
+			var st *StructTrace
+			if tr != nil {
+				st = &StructTrace{}
+				tr.Struct = st
+			}
+			return cs.hash(path, m, st)
+		}, nil
+	}
+
+	switch typeName {
+	case "string", "address":
+		return func(path string, value interface{}, _ *ValueTrace) ([]byte, error) {
+			str, ok := value.(string)
+			if !ok {
+				return nil, mismatch(path, typeName, value)
+			}
+			if typeName == "address" {
+				if err := ValidateAddress(str); err != nil {
+					return nil, &EncodeError{Path: path, Type: typeName, Err: err}
+				}
+			}
+			h := sha256.Sum256([]byte(str))
+			return h[:], nil
+		}, nil
+	case "bool":
+		return func(path string, value interface{}, _ *ValueTrace) ([]byte, error) {
+			return encodeBool(path, typeName, value)
+		}, nil
+	case "bytes":
+		return func(path string, value interface{}, _ *ValueTrace) ([]byte, error) {
+			raw, err := toBytes(value)
+			if err != nil {
+				return nil, &EncodeError{Path: path, Type: typeName, Err: err}
+			}
+			h := sha256.Sum256(raw)
+			return h[:], nil
+		}, nil
+	}
+
+	if n, ok := parseFixedBytesType(typeName); ok {
+		return func(path string, value interface{}, _ *ValueTrace) ([]byte, error) {
+			return encodeFixedBytes(path, typeName, n, value)
+		}, nil
+	}
+	if bits, signed, ok := parseIntType(typeName); ok {
+		return func(path string, value interface{}, _ *ValueTrace) ([]byte, error) {
+			return encodeInteger(path, typeName, bits, signed, value)
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownType, typeName)
+}
+
+func arrayEncoder(typeName, elemType string, length int, elem valueEncoder) valueEncoder {
+	return func(path string, value interface{}, tr *ValueTrace) ([]byte, error) {
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, mismatch(path, typeName, value)
+		}
+		if length >= 0 && rv.Len() != length {
+			return nil, &EncodeError{
+				Path: path,
+				Type: typeName,
+				Err:  fmt.Errorf("%w: expected %d elements, got %d", ErrOutOfRange, length, rv.Len()),
+			}
+		}
+		var buf bytes.Buffer
+		for i := 0; i < rv.Len(); i++ {
+			var et *ValueTrace
+			if tr != nil {
+				et = &ValueTrace{Type: elemType}
+				tr.Elements = append(tr.Elements, et)
+			}
+			encoded, err := elem(fmt.Sprintf("%s[%d]", path, i), rv.Index(i).Interface(), et)
+			if err != nil {
+				return nil, err
+			}
+			if et != nil {
+				et.Encoded = hex.EncodeToString(encoded)
+			}
+			buf.Write(encoded)
+		}
+		hash := sha256.Sum256(buf.Bytes())
+		return hash[:], nil
+	}
+}
+
+// hash checks data against the declared members and returns the struct
+// hash: SHA-256 of the type hash followed by each member's encoding. When
+// tr is non-nil it is filled with the struct's trace.
+func (cs *compiledStruct) hash(path string, data map[string]interface{}, tr *StructTrace) ([]byte, error) {
+	if data == nil {
+		return nil, &EncodeError{Path: path, Type: cs.name, Err: ErrMissingField}
+	}
+
+	var extra []string
+	for name := range data {
+		if !cs.declared[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return nil, &EncodeError{Path: path + "." + extra[0], Type: cs.name, Err: ErrUnknownField}
+	}
+
+	if tr != nil {
+		tr.Type = cs.name
+		tr.EncodedType = cs.typeString
+		tr.TypeHash = hex.EncodeToString(cs.typeHash[:])
+	}
+
+	var buf bytes.Buffer
+	buf.Write(cs.typeHash[:])
+	for _, member := range cs.members {
+		memberPath := path + "." + member.name
+		val, ok := data[member.name]
+		if !ok {
+			return nil, &EncodeError{Path: memberPath, Type: member.typ, Err: ErrMissingField}
+		}
+		var mt *ValueTrace
+		if tr != nil {
+			mt = &ValueTrace{Name: member.name, Type: member.typ}
+			tr.Members = append(tr.Members, mt)
+		}
+		encoded, err := member.encode(memberPath, val, mt)
+		if err != nil {
+			return nil, err
+		}
+		if mt != nil {
+			mt.Encoded = hex.EncodeToString(encoded)
+		}
+		buf.Write(encoded)
+	}
+
+	hash := sha256.Sum256(buf.Bytes())
+	if tr != nil {
+		tr.Hash = hex.EncodeToString(hash[:])
+	}
+	return hash[:], nil
+}
+
+// Registry caches compiled schemas keyed by their type string, so callers
+// hashing many messages of the same shape compile each schema only once.
+// The zero value is ready to use and safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Compile returns the cached schema for types and primaryType, compiling
+// it on first use.
+func (r *Registry) Compile(types map[string][]TypedMember, primaryType string) (*Schema, error) {
+	key := primaryType + "\x00" + encodeType(primaryType, types)
+
+	r.mu.RLock()
+	s, ok := r.schemas[key]
+	r.mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := CompileSchema(types, primaryType)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.schemas[key]; ok {
+		return existing, nil
+	}
+	if r.schemas == nil {
+		r.schemas = make(map[string]*Schema)
+	}
+	r.schemas[key] = s
+	return s, nil
+}
+
+// HashTypedData is HashTypedData using a cached schema.
+func (r *Registry) HashTypedData(data TypedData) ([]byte, error) {
+	s, err := r.Compile(data.Types, data.PrimaryType)
+	if err != nil {
+		return nil, err
+	}
+	return s.Hash(data.Domain, data.Message)
+}
@@ -0,0 +1,131 @@
+package osm15
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidKey is returned when key material cannot be used for signing.
+var ErrInvalidKey = errors.New("invalid key")
+
+// Signer produces Ed25519 signatures over OSM-15 digests. Implementations
+// may keep the private key in memory, in an HSM or behind a remote service.
+type Signer interface {
+	// Public returns the Ed25519 public key the signatures verify against.
+	Public() ed25519.PublicKey
+	// SignDigest signs the 32-byte digest returned by HashTypedData.
+	SignDigest(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// TypedDataSigner is implemented by signers that must see the typed data
+// rather than just its digest, such as remote services that check what
+// they sign. SignTypedDataWith prefers it over SignDigest.
+type TypedDataSigner interface {
+	Signer
+	// SignTypedData signs the digest of data and returns the raw signature.
+	SignTypedData(ctx context.Context, data TypedData) ([]byte, error)
+}
+
+type keySigner struct {
+	priv ed25519.PrivateKey
+}
+
+// NewKeySigner returns an in-memory Signer for a 32-byte Ed25519 seed.
+func NewKeySigner(seed []byte) (Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: seed must be %d bytes, got %d", ErrInvalidKey, ed25519.SeedSize, len(seed))
+	}
+	return &keySigner{priv: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// NewKeySignerFromBase64 is NewKeySigner for a base64 seed as produced by
+// GenerateKeypair and DecryptKey.
+func NewKeySignerFromBase64(privateKeyB64 string) (Signer, error) {
+	seed, err := base64.StdEncoding.DecodeString(privateKeyB64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: private key is not valid base64", ErrInvalidKey)
+	}
+	return NewKeySigner(seed)
+}
+
+// NewKeystoreSigner decrypts an encrypted keystore and returns an in-memory
+// Signer for it, so callers never handle the raw seed.
+func NewKeystoreSigner(keystoreJSON []byte, password string) (Signer, error) {
+	privateKeyB64, err := DecryptKey(keystoreJSON, password)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySignerFromBase64(privateKeyB64)
+}
+
+func (s *keySigner) Public() ed25519.PublicKey {
+	return s.priv.Public().(ed25519.PublicKey)
+}
+
+func (s *keySigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(s.priv, digest), nil
+}
+
+type cryptoSigner struct {
+	signer crypto.Signer
+	pub    ed25519.PublicKey
+}
+
+// NewCryptoSigner adapts a crypto.Signer holding an Ed25519 key, such as
+// an ed25519.PrivateKey or a hardware-backed implementation.
+func NewCryptoSigner(s crypto.Signer) (Signer, error) {
+	pub, ok := s.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: crypto.Signer holds a %T, not an Ed25519 key", ErrInvalidKey, s.Public())
+	}
+	return &cryptoSigner{signer: s, pub: pub}, nil
+}
+
+func (s *cryptoSigner) Public() ed25519.PublicKey {
+	return s.pub
+}
+
+func (s *cryptoSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Pure Ed25519: the digest is the message and is not pre-hashed.
+	return s.signer.Sign(rand.Reader, digest, crypto.Hash(0))
+}
+
+// SignTypedDataWith validates and hashes data, signs the digest with signer
+// and returns the base64 signature. The signature is checked against the
+// signer's public key and the locally computed digest before it is
+// returned, so a TypedDataSigner cannot sign something else.
+func SignTypedDataWith(ctx context.Context, data TypedData, signer Signer) (string, error) {
+	if diags := data.Validate(); len(diags) > 0 {
+		return "", &SchemaError{Diagnostics: diags}
+	}
+	digest, err := HashTypedData(data)
+	if err != nil {
+		return "", err
+	}
+
+	var sig []byte
+	if tds, ok := signer.(TypedDataSigner); ok {
+		sig, err = tds.SignTypedData(ctx, data)
+	} else {
+		sig, err = signer.SignDigest(ctx, digest)
+	}
+	if err != nil {
+		return "", err
+	}
+	pub := signer.Public()
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, digest, sig) {
+		return "", fmt.Errorf("osm15: signer returned a signature that does not verify")
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
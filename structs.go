@@ -0,0 +1,365 @@
+package osm15
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned by Decode when the Go struct does not
+// describe the same schema as the typed data.
+var ErrSchemaMismatch = errors.New("schema mismatch")
+
+var (
+	bigIntType = reflect.TypeOf(big.Int{})
+	byteType   = reflect.TypeOf(byte(0))
+)
+
+// FromStruct builds TypedData from a Go struct. Each exported field becomes
+// a member; the tag `osm15:"name,type"` sets the member name and type, and
+// `osm15:"-"` skips the field. Without an explicit type the member type is
+// inferred: strings, bools and sized Go integers map to their OSM-15
+// counterparts, big.Int to int256, []byte to bytes, [N]byte to bytesN,
+// slices and arrays to T[] and T[N], and nested structs to a struct type
+// named after the Go type (or after the tag's base type when one is set).
+func FromStruct(domain TypedDomain, v interface{}) (TypedData, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() == bigIntType {
+		return TypedData{}, fmt.Errorf("osm15: FromStruct expects a struct, got %T", v)
+	}
+
+	b := newStructBuilder()
+	primary, err := b.inferType(rv.Type())
+	if err != nil {
+		return TypedData{}, err
+	}
+	message, _ := b.messageValue(rv).(map[string]interface{})
+
+	return TypedData{
+		Domain:      domain,
+		Types:       b.types,
+		PrimaryType: primary,
+		Message:     message,
+	}, nil
+}
+
+// Decode populates the struct pointed to by out from data.Message. The
+// schema derived from out must match data's primary type exactly.
+func Decode(data TypedData, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("osm15: Decode expects a non-nil struct pointer, got %T", out)
+	}
+
+	b := newStructBuilder()
+	primary, err := b.inferType(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	want := encodeType(primary, b.types)
+	got := encodeType(data.PrimaryType, data.Types)
+	if want != got {
+		return &EncodeError{
+			Path: "message",
+			Type: data.PrimaryType,
+			Err:  fmt.Errorf("%w: struct describes %q", ErrSchemaMismatch, want),
+		}
+	}
+	if data.Message == nil {
+		return &EncodeError{Path: "message", Type: primary, Err: ErrMissingField}
+	}
+	return decodeValue("message", rv.Elem(), data.Message)
+}
+
+type structField struct {
+	index   int
+	name    string
+	tagType string
+}
+
+// structFields lists the exported, non-skipped fields of t in order.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("osm15")
+		if tag == "-" {
+			continue
+		}
+		name, tagType := tag, ""
+		if comma := strings.IndexByte(tag, ','); comma >= 0 {
+			name, tagType = tag[:comma], tag[comma+1:]
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{index: i, name: name, tagType: tagType})
+	}
+	return fields
+}
+
+type structBuilder struct {
+	types   map[string][]TypedMember
+	goTypes map[string]reflect.Type
+}
+
+func newStructBuilder() *structBuilder {
+	return &structBuilder{
+		types:   make(map[string][]TypedMember),
+		goTypes: make(map[string]reflect.Type),
+	}
+}
+
+// register records the members of struct type t under name.
+func (b *structBuilder) register(name string, t reflect.Type) error {
+	if existing, ok := b.goTypes[name]; ok {
+		if existing != t {
+			return fmt.Errorf("osm15: struct type %q is declared by both %s and %s", name, existing, t)
+		}
+		return nil
+	}
+	b.goTypes[name] = t
+
+	members := make([]TypedMember, 0, t.NumField())
+	for _, f := range structFields(t) {
+		typ, err := b.memberType(t.Field(f.index).Type, f.tagType)
+		if err != nil {
+			return fmt.Errorf("osm15: %s.%s: %w", t.Name(), t.Field(f.index).Name, err)
+		}
+		members = append(members, TypedMember{Name: f.name, Type: typ})
+	}
+	b.types[name] = members
+	return nil
+}
+
+// memberType returns tagType when set, registering any struct it refers
+// to, and otherwise infers the type from t.
+func (b *structBuilder) memberType(t reflect.Type, tagType string) (string, error) {
+	if tagType == "" {
+		return b.inferType(t)
+	}
+	if st := structElem(t); st != nil {
+		if err := b.register(baseType(tagType), st); err != nil {
+			return "", err
+		}
+	}
+	return tagType, nil
+}
+
+func (b *structBuilder) inferType(t reflect.Type) (string, error) {
+	if t == bigIntType || (t.Kind() == reflect.Ptr && t.Elem() == bigIntType) {
+		return "int256", nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.inferType(t.Elem())
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("int%d", t.Bits()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("uint%d", t.Bits()), nil
+	case reflect.Slice:
+		if t.Elem() == byteType {
+			return "bytes", nil
+		}
+		elem, err := b.inferType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return elem + "[]", nil
+	case reflect.Array:
+		if t.Elem() == byteType {
+			if t.Len() < 1 || t.Len() > 32 {
+				return "", fmt.Errorf("byte array of length %d has no bytesN type", t.Len())
+			}
+			return fmt.Sprintf("bytes%d", t.Len()), nil
+		}
+		elem, err := b.inferType(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s[%d]", elem, t.Len()), nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return "", fmt.Errorf("anonymous struct needs an explicit type tag")
+		}
+		if err := b.register(t.Name(), t); err != nil {
+			return "", err
+		}
+		return t.Name(), nil
+	}
+	return "", fmt.Errorf("unsupported Go type %s", t)
+}
+
+// structElem returns the struct type found beneath pointers, slices and
+// arrays of t, or nil when t does not hold a struct.
+func structElem(t reflect.Type) reflect.Type {
+	for {
+		switch {
+		case t == bigIntType:
+			return nil
+		case t.Kind() == reflect.Ptr, t.Kind() == reflect.Slice, t.Kind() == reflect.Array:
+			if t.Elem() == byteType {
+				return nil
+			}
+			t = t.Elem()
+		case t.Kind() == reflect.Struct:
+			return t
+		default:
+			return nil
+		}
+	}
+}
+
+// messageValue converts rv to the map/slice form used in TypedData.Message.
+func (b *structBuilder) messageValue(rv reflect.Value) interface{} {
+	if rv.Type() == bigIntType {
+		n := rv.Interface().(big.Int)
+		return new(big.Int).Set(&n)
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return b.messageValue(rv.Elem())
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem() == byteType {
+			raw := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(raw), rv)
+			return raw
+		}
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return []interface{}{}
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = b.messageValue(rv.Index(i))
+		}
+		return items
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for _, f := range structFields(rv.Type()) {
+			m[f.name] = b.messageValue(rv.Field(f.index))
+		}
+		return m
+	}
+	return rv.Interface()
+}
+
+// decodeValue stores value into the settable rv, converting from any of the
+// representations the encoder accepts.
+func decodeValue(path string, rv reflect.Value, value interface{}) error {
+	fail := func(err error) error {
+		return &EncodeError{Path: path, Type: rv.Type().String(), Err: err}
+	}
+
+	if rv.Type() == bigIntType {
+		n, err := toBigInt(value)
+		if err != nil {
+			return fail(err)
+		}
+		rv.Set(reflect.ValueOf(*n))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(path, rv.Elem(), value)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fail(fmt.Errorf("%w: got %T", ErrTypeMismatch, value))
+		}
+		rv.SetString(s)
+	case reflect.Bool:
+		v, ok := value.(bool)
+		if !ok {
+			return fail(fmt.Errorf("%w: got %T", ErrTypeMismatch, value))
+		}
+		rv.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toBigInt(value)
+		if err != nil {
+			return fail(err)
+		}
+		if !n.IsInt64() || rv.OverflowInt(n.Int64()) {
+			return fail(fmt.Errorf("%w: %s", ErrOutOfRange, n))
+		}
+		rv.SetInt(n.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := toBigInt(value)
+		if err != nil {
+			return fail(err)
+		}
+		if !n.IsUint64() || rv.OverflowUint(n.Uint64()) {
+			return fail(fmt.Errorf("%w: %s", ErrOutOfRange, n))
+		}
+		rv.SetUint(n.Uint64())
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem() == byteType {
+			raw, err := toBytes(value)
+			if err != nil {
+				return fail(err)
+			}
+			if rv.Kind() == reflect.Array {
+				if len(raw) != rv.Len() {
+					return fail(fmt.Errorf("%w: expected %d bytes, got %d", ErrOutOfRange, rv.Len(), len(raw)))
+				}
+				reflect.Copy(rv, reflect.ValueOf(raw))
+			} else {
+				rv.SetBytes(append([]byte(nil), raw...))
+			}
+			return nil
+		}
+		src := reflect.ValueOf(value)
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			return fail(fmt.Errorf("%w: got %T", ErrTypeMismatch, value))
+		}
+		if rv.Kind() == reflect.Array && src.Len() != rv.Len() {
+			return fail(fmt.Errorf("%w: expected %d elements, got %d", ErrOutOfRange, rv.Len(), src.Len()))
+		}
+		if rv.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(rv.Type(), src.Len(), src.Len()))
+		}
+		for i := 0; i < src.Len(); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			if err := decodeValue(elemPath, rv.Index(i), src.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fail(fmt.Errorf("%w: got %T", ErrTypeMismatch, value))
+		}
+		for _, f := range structFields(rv.Type()) {
+			v, ok := m[f.name]
+			if !ok {
+				return &EncodeError{Path: path + "." + f.name, Err: ErrMissingField}
+			}
+			if err := decodeValue(path+"."+f.name, rv.Field(f.index), v); err != nil {
+				return err
+			}
+		}
+	default:
+		return fail(fmt.Errorf("unsupported Go type %s", rv.Type()))
+	}
+	return nil
+}
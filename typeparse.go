@@ -0,0 +1,46 @@
+package osm15
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitArrayType splits the outermost array dimension off typeName. As in
+// Solidity, the last suffix is the outermost one: "Transfer[3][]" is a
+// dynamic array whose elements are "Transfer[3]". length is -1 for a
+// dynamic array. ok is false when typeName is not an array type.
+func splitArrayType(typeName string) (elem string, length int, ok bool, err error) {
+	if !strings.HasSuffix(typeName, "]") {
+		if strings.ContainsAny(typeName, "[]") {
+			return "", 0, false, fmt.Errorf("%w: malformed array type %q", ErrUnknownType, typeName)
+		}
+		return "", 0, false, nil
+	}
+
+	open := strings.LastIndexByte(typeName, '[')
+	if open <= 0 {
+		return "", 0, false, fmt.Errorf("%w: malformed array type %q", ErrUnknownType, typeName)
+	}
+	elem, size := typeName[:open], typeName[open+1:len(typeName)-1]
+	if size == "" {
+		return elem, -1, true, nil
+	}
+	if size[0] == '0' || strings.ContainsAny(size, "+-") {
+		return "", 0, false, fmt.Errorf("%w: invalid array length in %q", ErrUnknownType, typeName)
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("%w: invalid array length in %q", ErrUnknownType, typeName)
+	}
+	return elem, n, true, nil
+}
+
+// baseType strips every array dimension from typeName, so "Transfer[3][]"
+// becomes "Transfer".
+func baseType(typeName string) string {
+	if i := strings.IndexByte(typeName, '['); i >= 0 {
+		return typeName[:i]
+	}
+	return typeName
+}
@@ -0,0 +1,204 @@
+package osm15
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Diagnostic codes reported by ValidateTypes and TypedData.Validate.
+const (
+	DiagMissingPrimaryType = "missing-primary-type"
+	DiagUndefinedType      = "undefined-type"
+	DiagInvalidType        = "invalid-type"
+	DiagInvalidName        = "invalid-name"
+	DiagDuplicateMember    = "duplicate-member"
+	DiagEmptyStruct        = "empty-struct"
+	DiagCyclicType         = "cyclic-type"
+	DiagInvalidDomain      = "invalid-domain"
+)
+
+// Diagnostic is one problem found in a schema. Path points at the offending
+// entry, e.g. "types.Order[1]" for the second member of Order.
+type Diagnostic struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Path, d.Code, d.Message)
+}
+
+// SchemaError is returned when signing data whose schema fails validation.
+type SchemaError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *SchemaError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+	return "osm15: invalid schema: " + strings.Join(msgs, "; ")
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isPrimitiveType reports whether typeName is a built-in atomic type.
+func isPrimitiveType(typeName string) bool {
+	switch typeName {
+	case "string", "address", "bool", "bytes":
+		return true
+	}
+	if _, ok := parseFixedBytesType(typeName); ok {
+		return true
+	}
+	_, _, ok := parseIntType(typeName)
+	return ok
+}
+
+// ValidateTypes checks a type map without hashing anything. It reports
+// invalid struct and member names, duplicate members, empty structs,
+// malformed or undefined member types and cyclic type references. The
+// reserved "TypedDomain" entry is ignored since the domain type is always
+// derived from the domain. An empty result means the schema is valid.
+func ValidateTypes(types map[string][]TypedMember) []Diagnostic {
+	var diags []Diagnostic
+	report := func(path, code, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Path: path, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		if name != "TypedDomain" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := "types." + name
+		if !identifierPattern.MatchString(name) || isPrimitiveType(name) {
+			report(path, DiagInvalidName, "%q is not a valid struct type name", name)
+		}
+		if len(types[name]) == 0 {
+			report(path, DiagEmptyStruct, "struct type %s has no members", name)
+		}
+
+		seen := make(map[string]bool)
+		for i, member := range types[name] {
+			memberPath := fmt.Sprintf("%s[%d]", path, i)
+			if !identifierPattern.MatchString(member.Name) {
+				report(memberPath, DiagInvalidName, "%q is not a valid member name", member.Name)
+			} else if seen[member.Name] {
+				report(memberPath, DiagDuplicateMember, "member %q is declared more than once", member.Name)
+			}
+			seen[member.Name] = true
+
+			if err := checkMemberType(member.Type); err != nil {
+				report(memberPath, DiagInvalidType, "%v", err)
+				continue
+			}
+			base := baseType(member.Type)
+			if _, ok := types[base]; !ok && !isPrimitiveType(base) {
+				report(memberPath, DiagUndefinedType, "type %q is not defined", base)
+			}
+		}
+	}
+
+	for _, cycle := range findCycles(names, types) {
+		report("types."+cycle[0], DiagCyclicType, "cyclic reference %s", strings.Join(cycle, " -> "))
+	}
+	return diags
+}
+
+// Validate checks the schema with ValidateTypes, and also that PrimaryType
+// names a defined struct and that the domain is well formed.
+func (d TypedData) Validate() []Diagnostic {
+	var diags []Diagnostic
+	if d.PrimaryType == "" {
+		diags = append(diags, Diagnostic{Path: "primaryType", Code: DiagMissingPrimaryType, Message: "primaryType is empty"})
+	} else if _, ok := d.Types[d.PrimaryType]; !ok || d.PrimaryType == "TypedDomain" {
+		diags = append(diags, Diagnostic{
+			Path:    "primaryType",
+			Code:    DiagUndefinedType,
+			Message: fmt.Sprintf("primary type %q is not defined", d.PrimaryType),
+		})
+	}
+
+	if err := d.Domain.validate(); err != nil {
+		path := "domain"
+		var encErr *EncodeError
+		if errors.As(err, &encErr) {
+			path = encErr.Path
+		}
+		diags = append(diags, Diagnostic{Path: path, Code: DiagInvalidDomain, Message: err.Error()})
+	}
+
+	return append(diags, ValidateTypes(d.Types)...)
+}
+
+// checkMemberType parses every array dimension of typeName.
+func checkMemberType(typeName string) error {
+	for {
+		elem, _, ok, err := splitArrayType(typeName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !identifierPattern.MatchString(typeName) {
+				return fmt.Errorf("%w: %q is not a valid type", ErrUnknownType, typeName)
+			}
+			return nil
+		}
+		typeName = elem
+	}
+}
+
+// findCycles returns each cycle in the struct reference graph once, as the
+// list of type names starting and ending with the same type.
+func findCycles(names []string, types map[string][]TypedMember) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, member := range types[name] {
+			dep := baseType(member.Type)
+			if _, ok := types[dep]; !ok || dep == "TypedDomain" {
+				continue
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				start := len(stack) - 1
+				for stack[start] != dep {
+					start--
+				}
+				cycle := append(append([]string(nil), stack[start:]...), dep)
+				cycles = append(cycles, cycle)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
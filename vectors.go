@@ -0,0 +1,196 @@
+package osm15
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//go:generate go run ./cmd/osm15 vectors -in testdata/vectors.json -out testdata/vectors.json
+
+// VectorsVersion is the version of the test vector format. It changes
+// whenever the encoding rules change in a way that alters any digest.
+const VectorsVersion = 1
+
+// VectorFile is a versioned corpus of cross-implementation test vectors.
+type VectorFile struct {
+	Version     int          `json:"version"`
+	Description string       `json:"description,omitempty"`
+	Vectors     []TestVector `json:"vectors"`
+}
+
+// TestVector pins every intermediate result for one TypedData input. Seed
+// is the hex Ed25519 seed used for the signature. Vectors with Error set
+// describe inputs that every implementation must reject; Error holds one
+// of the codes returned by ErrorCode.
+type TestVector struct {
+	Name     string             `json:"name"`
+	Data     TypedData          `json:"data"`
+	Seed     string             `json:"seed,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Expected *VectorExpectation `json:"expected,omitempty"`
+}
+
+// VectorExpectation holds the expected outputs of a valid vector. Hashes
+// are hex; PublicKey and Signature are base64 as elsewhere in the package.
+// StructHashes pins the hashStruct of every struct nested in the message,
+// keyed by its path, e.g. "message.from" or "message.assets[1]", so a
+// mismatch can be traced to the struct that differs.
+type VectorExpectation struct {
+	TypeStrings  map[string]string `json:"typeStrings"`
+	StructHashes map[string]string `json:"structHashes,omitempty"`
+	DomainHash   string            `json:"domainHash"`
+	MessageHash  string            `json:"messageHash"`
+	Digest       string            `json:"digest"`
+	PublicKey    string            `json:"publicKey"`
+	Address      string            `json:"address"`
+	Signature    string            `json:"signature"`
+}
+
+// ErrorCode maps an encoding error to the language-neutral code used in
+// test vectors, or "" when err is nil.
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrUnknownType):
+		return "unknown-type"
+	case errors.Is(err, ErrTypeMismatch):
+		return "type-mismatch"
+	case errors.Is(err, ErrMissingField):
+		return "missing-field"
+	case errors.Is(err, ErrUnknownField):
+		return "undeclared-field"
+	case errors.Is(err, ErrOutOfRange):
+		return "out-of-range"
+	}
+	return "invalid"
+}
+
+// GenerateVector computes the expected outputs for data signed with the
+// hex seed. If data cannot be hashed the vector records the error code
+// instead.
+func GenerateVector(name string, data TypedData, seedHex string) (TestVector, error) {
+	v := TestVector{Name: name, Data: data, Seed: seedHex}
+
+	trace, err := Explain(data)
+	if err != nil {
+		v.Error = ErrorCode(err)
+		return v, nil
+	}
+
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return v, fmt.Errorf("osm15: vector %q: seed must be %d hex bytes", name, ed25519.SeedSize)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := priv.Public().(ed25519.PublicKey)
+	digest, _ := hex.DecodeString(trace.Digest)
+
+	typeStrings := map[string]string{
+		"TypedDomain": trace.Domain.EncodedType,
+	}
+	schema, _ := CompileSchema(data.Types, data.PrimaryType)
+	for name, cs := range schema.structs {
+		typeStrings[name] = cs.typeString
+	}
+
+	structHashes := make(map[string]string)
+	collectStructHashes(structHashes, "message", trace.Message)
+	if len(structHashes) == 0 {
+		structHashes = nil
+	}
+
+	v.Expected = &VectorExpectation{
+		TypeStrings:  typeStrings,
+		StructHashes: structHashes,
+		DomainHash:   trace.Domain.Hash,
+		MessageHash:  trace.Message.Hash,
+		Digest:       trace.Digest,
+		PublicKey:    base64.StdEncoding.EncodeToString(pub),
+		Address:      PublicKeyToAddress(pub),
+		Signature:    base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest)),
+	}
+	return v, nil
+}
+
+// collectStructHashes records the hash of every struct below st by path.
+func collectStructHashes(hashes map[string]string, path string, st *StructTrace) {
+	for _, m := range st.Members {
+		collectValueHashes(hashes, path+"."+m.Name, m)
+	}
+}
+
+func collectValueHashes(hashes map[string]string, path string, vt *ValueTrace) {
+	if vt.Struct != nil {
+		hashes[path] = vt.Struct.Hash
+		collectStructHashes(hashes, path, vt.Struct)
+	}
+	for i, e := range vt.Elements {
+		collectValueHashes(hashes, fmt.Sprintf("%s[%d]", path, i), e)
+	}
+}
+
+// Check recomputes the vector and reports the first field that differs.
+func (v TestVector) Check() error {
+	got, err := GenerateVector(v.Name, v.Data, v.Seed)
+	if err != nil {
+		return err
+	}
+	if got.Error != v.Error {
+		return fmt.Errorf("vector %q: error = %q, want %q", v.Name, got.Error, v.Error)
+	}
+	if v.Error != "" {
+		return nil
+	}
+	if v.Expected == nil {
+		return fmt.Errorf("vector %q: no expected values", v.Name)
+	}
+
+	want, have := v.Expected, got.Expected
+	names := make([]string, 0, len(want.TypeStrings))
+	for name := range want.TypeStrings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if have.TypeStrings[name] != want.TypeStrings[name] {
+			return fmt.Errorf("vector %q: type string of %s = %q, want %q", v.Name, name, have.TypeStrings[name], want.TypeStrings[name])
+		}
+	}
+	if len(have.TypeStrings) != len(want.TypeStrings) {
+		return fmt.Errorf("vector %q: %d type strings, want %d", v.Name, len(have.TypeStrings), len(want.TypeStrings))
+	}
+
+	paths := make([]string, 0, len(want.StructHashes))
+	for path := range want.StructHashes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if have.StructHashes[path] != want.StructHashes[path] {
+			return fmt.Errorf("vector %q: hash of %s = %s, want %s", v.Name, path, have.StructHashes[path], want.StructHashes[path])
+		}
+	}
+	if len(have.StructHashes) != len(want.StructHashes) {
+		return fmt.Errorf("vector %q: %d struct hashes, want %d", v.Name, len(have.StructHashes), len(want.StructHashes))
+	}
+
+	fields := []struct{ name, have, want string }{
+		{"domainHash", have.DomainHash, want.DomainHash},
+		{"messageHash", have.MessageHash, want.MessageHash},
+		{"digest", have.Digest, want.Digest},
+		{"publicKey", have.PublicKey, want.PublicKey},
+		{"address", have.Address, want.Address},
+		{"signature", have.Signature, want.Signature},
+	}
+	for _, f := range fields {
+		if f.have != f.want {
+			return fmt.Errorf("vector %q: %s = %s, want %s", v.Name, f.name, f.have, f.want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+// Package wallet manages a directory of keystores, one per account, with
+// labels, a default account and in-memory unlocking.
+//
+// Each account is stored as <address>.json, a regular osm15 keystore that
+// can be used with any -wallet flag. Labels and the default account are
+// kept in accounts.json next to them. The directory defaults to
+// ~/.osm15/keystore and can be moved with OSM15_KEYSTORE.
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dayuwidayadi57/osm15"
+	"github.com/dayuwidayadi57/osm15/internal/atomicfile"
+)
+
+// EnvDir is the environment variable that overrides DefaultDir.
+const EnvDir = "OSM15_KEYSTORE"
+
+const metadataFile = "accounts.json"
+
+// Errors returned by Wallet.
+var (
+	ErrNotFound   = errors.New("wallet: no such account")
+	ErrExists     = errors.New("wallet: account already exists")
+	ErrLabelTaken = errors.New("wallet: label is already in use")
+	ErrLocked     = errors.New("wallet: account is locked")
+	ErrNoDefault  = errors.New("wallet: no default account")
+)
+
+// Account describes one keystore in the wallet.
+type Account struct {
+	Address  string `json:"address"`
+	Label    string `json:"label,omitempty"`
+	Path     string `json:"path"`
+	Default  bool   `json:"default,omitempty"`
+	Unlocked bool   `json:"unlocked,omitempty"`
+}
+
+type metadata struct {
+	Default string            `json:"default,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Wallet is a keystore directory. Accounts are named by address or label
+// wherever a name is taken. Unlocked keys live only in this process.
+type Wallet struct {
+	dir string
+
+	mu       sync.Mutex
+	unlocked map[string]osm15.Signer
+}
+
+// DefaultDir returns $OSM15_KEYSTORE, or ~/.osm15/keystore.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv(EnvDir); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".osm15", "keystore"), nil
+}
+
+// Open opens the wallet in dir, creating the directory (mode 0700) if it
+// does not exist.
+func Open(dir string) (*Wallet, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Wallet{dir: dir, unlocked: make(map[string]osm15.Signer)}, nil
+}
+
+// Dir returns the wallet directory.
+func (w *Wallet) Dir() string {
+	return w.dir
+}
+
+// List returns every account, sorted by label and then address.
+func (w *Wallet) List() ([]Account, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.list()
+}
+
+func (w *Wallet) list() ([]Account, error) {
+	meta, err := w.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(w.dir, "oct*.json"))
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]Account, 0, len(paths))
+	for _, path := range paths {
+		address := strings.TrimSuffix(filepath.Base(path), ".json")
+		_, unlocked := w.unlocked[address]
+		accounts = append(accounts, Account{
+			Address:  address,
+			Label:    meta.Labels[address],
+			Path:     path,
+			Default:  meta.Default == address,
+			Unlocked: unlocked,
+		})
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Label != accounts[j].Label {
+			return accounts[i].Label < accounts[j].Label
+		}
+		return accounts[i].Address < accounts[j].Address
+	})
+	return accounts, nil
+}
+
+// Find returns the account with the given address or label.
+func (w *Wallet) Find(name string) (Account, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.find(name)
+}
+
+func (w *Wallet) find(name string) (Account, error) {
+	accounts, err := w.list()
+	if err != nil {
+		return Account{}, err
+	}
+	for _, a := range accounts {
+		if a.Address == name {
+			return a, nil
+		}
+	}
+	for _, a := range accounts {
+		if a.Label != "" && a.Label == name {
+			return a, nil
+		}
+	}
+	return Account{}, fmt.Errorf("%w: %q", ErrNotFound, name)
+}
+
+// New generates a key, stores it encrypted with password and opts, and
+// labels it. The first account in a wallet becomes the default.
+func (w *Wallet) New(password, label string, opts osm15.KeystoreOptions) (Account, error) {
+	privB64, _, err := osm15.GenerateKeypair()
+	if err != nil {
+		return Account{}, err
+	}
+	keystoreJSON, err := osm15.EncryptKeyWithOptions(privB64, password, opts)
+	if err != nil {
+		return Account{}, err
+	}
+	return w.add(keystoreJSON, privB64, label)
+}
+
+// Import adds an existing keystore. The password is needed to check that
+// the keystore really holds the key for its address; the file is stored
+// as given.
+func (w *Wallet) Import(keystoreJSON []byte, password, label string) (Account, error) {
+	privB64, err := osm15.DecryptKey(keystoreJSON, password)
+	if err != nil {
+		return Account{}, err
+	}
+	return w.add(keystoreJSON, privB64, label)
+}
+
+func (w *Wallet) add(keystoreJSON []byte, privB64, label string) (Account, error) {
+	signer, err := osm15.NewKeySignerFromBase64(privB64)
+	if err != nil {
+		return Account{}, err
+	}
+	address := osm15.PublicKeyToAddress(signer.Public())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	meta, err := w.readMetadata()
+	if err != nil {
+		return Account{}, err
+	}
+	if err := w.checkLabel(meta, address, label); err != nil {
+		return Account{}, err
+	}
+	path := filepath.Join(w.dir, address+".json")
+	if _, err := os.Stat(path); err == nil {
+		return Account{}, fmt.Errorf("%w: %s", ErrExists, address)
+	}
+	if err := atomicfile.WriteFile(path, keystoreJSON, 0600); err != nil {
+		return Account{}, err
+	}
+
+	if label != "" {
+		meta.Labels[address] = label
+	}
+	if meta.Default == "" {
+		meta.Default = address
+	}
+	if err := w.writeMetadata(meta); err != nil {
+		return Account{}, err
+	}
+	return w.find(address)
+}
+
+// Export returns the encrypted keystore of an account.
+func (w *Wallet) Export(name string) ([]byte, error) {
+	a, err := w.Find(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(a.Path)
+}
+
+// SetLabel renames an account. An empty label removes it.
+func (w *Wallet) SetLabel(name, label string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, err := w.find(name)
+	if err != nil {
+		return err
+	}
+	meta, err := w.readMetadata()
+	if err != nil {
+		return err
+	}
+	if err := w.checkLabel(meta, a.Address, label); err != nil {
+		return err
+	}
+	if label == "" {
+		delete(meta.Labels, a.Address)
+	} else {
+		meta.Labels[a.Address] = label
+	}
+	return w.writeMetadata(meta)
+}
+
+// Default returns the default account.
+func (w *Wallet) Default() (Account, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	meta, err := w.readMetadata()
+	if err != nil {
+		return Account{}, err
+	}
+	if meta.Default == "" {
+		return Account{}, ErrNoDefault
+	}
+	a, err := w.find(meta.Default)
+	if errors.Is(err, ErrNotFound) {
+		return Account{}, fmt.Errorf("%w: %s was removed", ErrNoDefault, meta.Default)
+	}
+	return a, err
+}
+
+// SetDefault makes an account the default.
+func (w *Wallet) SetDefault(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, err := w.find(name)
+	if err != nil {
+		return err
+	}
+	meta, err := w.readMetadata()
+	if err != nil {
+		return err
+	}
+	meta.Default = a.Address
+	return w.writeMetadata(meta)
+}
+
+// Unlock decrypts an account's keystore and keeps the key in memory until
+// Lock or LockAll.
+func (w *Wallet) Unlock(name, password string) error {
+	a, err := w.Find(name)
+	if err != nil {
+		return err
+	}
+	keystoreJSON, err := ioutil.ReadFile(a.Path)
+	if err != nil {
+		return err
+	}
+	signer, err := osm15.NewKeystoreSigner(keystoreJSON, password)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.unlocked[a.Address] = signer
+	w.mu.Unlock()
+	return nil
+}
+
+// Lock forgets an account's unlocked key.
+func (w *Wallet) Lock(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, err := w.find(name)
+	if err != nil {
+		return err
+	}
+	delete(w.unlocked, a.Address)
+	return nil
+}
+
+// LockAll forgets every unlocked key.
+func (w *Wallet) LockAll() {
+	w.mu.Lock()
+	w.unlocked = make(map[string]osm15.Signer)
+	w.mu.Unlock()
+}
+
+// Signer returns the signer of an unlocked account, or ErrLocked.
+func (w *Wallet) Signer(name string) (osm15.Signer, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, err := w.find(name)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := w.unlocked[a.Address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrLocked, a.Address)
+	}
+	return signer, nil
+}
+
+// checkLabel rejects a label held by another account, or one that could
+// be mistaken for an address.
+func (w *Wallet) checkLabel(meta *metadata, address, label string) error {
+	if label == "" {
+		return nil
+	}
+	if strings.HasPrefix(label, "oct") {
+		return fmt.Errorf("wallet: label %q must not start with \"oct\"", label)
+	}
+	for addr, l := range meta.Labels {
+		if l == label && addr != address {
+			return fmt.Errorf("%w: %q", ErrLabelTaken, label)
+		}
+	}
+	return nil
+}
+
+func (w *Wallet) readMetadata() (*metadata, error) {
+	meta := &metadata{}
+	data, err := ioutil.ReadFile(filepath.Join(w.dir, metadataFile))
+	if err == nil {
+		if err := json.Unmarshal(data, meta); err != nil {
+			return nil, fmt.Errorf("wallet: %s: %v", metadataFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	return meta, nil
+}
+
+func (w *Wallet) writeMetadata(meta *metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(filepath.Join(w.dir, metadataFile), data, 0600)
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dayuwidayadi57/osm15"
+)
+
+func TestWallet_Accounts(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	if _, err := w.Default(); !errors.Is(err, ErrNoDefault) {
+		t.Errorf("Empty wallet Default error = %v", err)
+	}
+
+	// 1. New and imported accounts are listed by label
+	treasury, err := w.New("pw1", "treasury", osm15.KeystoreOptions{})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	privB64, _, _ := osm15.GenerateKeypair()
+	ksJSON, _ := osm15.EncryptKey(privB64, "pw2")
+	if _, err := w.Import(ksJSON, "wrong", "ops"); !errors.Is(err, osm15.ErrInvalidPassword) {
+		t.Errorf("Import with a wrong password error = %v", err)
+	}
+	ops, err := w.Import(ksJSON, "pw2", "ops")
+	if err != nil {
+		t.Fatalf("Import error: %v", err)
+	}
+	if _, err := w.Import(ksJSON, "pw2", ""); !errors.Is(err, ErrExists) {
+		t.Errorf("Duplicate import error = %v", err)
+	}
+	if _, err := w.New("pw3", "ops", osm15.KeystoreOptions{}); !errors.Is(err, ErrLabelTaken) {
+		t.Errorf("Duplicate label error = %v", err)
+	}
+
+	accounts, err := w.List()
+	if err != nil || len(accounts) != 2 {
+		t.Fatalf("List = %+v, %v", accounts, err)
+	}
+	if accounts[0].Label != "ops" || accounts[1].Label != "treasury" || !accounts[1].Default {
+		t.Errorf("List = %+v", accounts)
+	}
+	if info, err := os.Stat(ops.Path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Keystore file mode = %v, %v", info.Mode(), err)
+	}
+
+	// 2. Accounts are found by label or address, and the default can move
+	if a, err := w.Find("ops"); err != nil || a.Address != ops.Address {
+		t.Errorf("Find by label = %+v, %v", a, err)
+	}
+	if err := w.SetDefault(ops.Address); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := w.Default(); a.Address != ops.Address {
+		t.Errorf("Default = %s, want %s", a.Address, ops.Address)
+	}
+	if err := w.SetLabel("treasury", "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Find("treasury"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Old label still resolves: %v", err)
+	}
+
+	// 3. Exported keystores are the stored file
+	exported, err := w.Export("ops")
+	if err != nil || string(exported) != string(ksJSON) {
+		t.Errorf("Export = %d bytes, %v", len(exported), err)
+	}
+
+	// 4. Signing needs an unlocked account
+	if _, err := w.Signer("cold"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Locked Signer error = %v", err)
+	}
+	if err := w.Unlock("cold", "wrong"); !errors.Is(err, osm15.ErrInvalidPassword) {
+		t.Errorf("Unlock with a wrong password error = %v", err)
+	}
+	if err := w.Unlock("cold", "pw1"); err != nil {
+		t.Fatalf("Unlock error: %v", err)
+	}
+	signer, err := w.Signer(treasury.Address)
+	if err != nil {
+		t.Fatalf("Signer error: %v", err)
+	}
+	data := osm15.TypedData{
+		Domain:      osm15.TypedDomain{Name: "Wallet", Version: "1", ChainID: 1},
+		Types:       map[string][]osm15.TypedMember{"Msg": {{Name: "text", Type: "string"}}},
+		PrimaryType: "Msg",
+		Message:     map[string]interface{}{"text": "hi"},
+	}
+	if _, err := osm15.SignTypedDataWith(context.Background(), data, signer); err != nil {
+		t.Errorf("Sign error: %v", err)
+	}
+	if a, _ := w.Find("cold"); !a.Unlocked {
+		t.Error("Unlocked account not reported as unlocked")
+	}
+	w.LockAll()
+	if _, err := w.Signer("cold"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Signer after LockAll error = %v", err)
+	}
+
+	// 5. A reopened wallet keeps labels and the default
+	w2, _ := Open(dir)
+	if a, _ := w2.Default(); a.Address != ops.Address || a.Label != "ops" {
+		t.Errorf("Reopened Default = %+v", a)
+	}
+}